@@ -1,13 +1,16 @@
 package acme
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/jtblin/go-logger"
@@ -15,29 +18,102 @@ import (
 
 	"github.com/jtblin/go-acme/backend"
 	_ "github.com/jtblin/go-acme/backend/backends" // import all backends.
+	"github.com/jtblin/go-acme/dnsprovider"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers" // import all DNS providers.
 	"github.com/jtblin/go-acme/types"
 )
 
 const (
+	// renewInterval is the interval between checks for certificate renewal.
+	renewInterval = 24 * time.Hour
+	// renewBefore is how long before expiry certificates are renewed.
+	renewBefore = 24 * 7 * time.Hour
 	// #2 - important set to true to bundle CA with certificate and
 	// avoid "transport: x509: certificate signed by unknown authority" error
 	bundleCA        = true
 	defaultCAServer = "https://acme-v01.api.letsencrypt.org/directory"
+	// maxSaveConflicts is the number of times an account modified concurrently is reloaded and saved again.
+	maxSaveConflicts = 3
 )
 
 // ACME allows to connect to lets encrypt and retrieve certs.
 type ACME struct {
-	backend     backend.Interface
-	Domain      *types.Domain
-	Logger      logger.Interface
-	BackendName string
-	CAServer    string
-	DNSProvider string
-	Email       string
-	SelfSigned  bool
+	account *types.Account
+	storage backend.ContextInterface
+	// ctx is the context of CreateConfigContext, used by the renewals and challenge callbacks.
+	ctx    context.Context
+	client *acme.Client
+	// httpClient is the http client of the requests to the CA.
+	httpClient *http.Client
+	// solvers holds the Solvers by lower case key.
+	solvers map[string]Solver
+	// dnsProviders holds the DNS providers by solver key, the default provider has an empty key.
+	dnsProviders map[string]acme.ChallengeProvider
+	// openProviders holds the DNS providers as created, closed when the context is done.
+	openProviders []acme.ChallengeProvider
+	// instanceID identifies the challenge records journaled by this instance.
+	instanceID string
+	// httpChallenges holds the HTTP-01 challenges in flight.
+	httpChallenges httpChallenges
+	// mu guards the account, the lego client, the certificate and renewal status.
+	mu         sync.RWMutex
+	caServer   string
+	nextCheck  time.Time
+	renewalErr error
+	// retry is the order scheduled at the end of the rate limit window exceeded.
+	retry *time.Timer
+	// orderMu serializes certificate orders.
+	orderMu sync.Mutex
+	// journalMu guards the journal of the challenge records presented.
+	journalMu sync.Mutex
+	Domain    *types.Domain
+	Logger    logger.Interface
+	// Backend is a ready-made storage backend, used instead of BackendName.
+	Backend backend.Interface
+	// BackendConfig is the storage backend configuration, unset values fall back to environment variables.
+	BackendConfig backend.Config
+	BackendName   string
+	// BackendTimeout is the deadline of each storage backend operation (default none).
+	BackendTimeout time.Duration
+	CAServer       string
+	// Challenges are the challenge types enabled to validate domains without a solver (default DNS-01).
+	// The lego client chooses amongst the types it solves in the order offered by the CA, TLS-ALPN-01
+	// is only used for the domains without another type enabled.
+	Challenges []acme.Challenge
+	// ChallengeAliases holds the domains whose DNS-01 challenge records are presented at
+	// _acme-challenge.<alias> by domain, the challenge names being delegated with a CNAME.
+	ChallengeAliases map[string]string
+	DNSProvider      string
+	// DNSConfig is the DNS provider configuration, unset values fall back to environment variables.
+	DNSConfig *dnsprovider.Config
+	Email     string
+	// FollowCNAME follows the CNAME records of the challenge names to present the DNS-01
+	// challenge records at the delegation target.
+	FollowCNAME bool
+	// HTTPAddress is the address of an optional listener answering HTTP-01 challenges
+	// and redirecting other requests to https e.g. ":80".
+	HTTPAddress string
+	// HTTPClient is the http client used for every ACME, OCSP and certificate chain request.
+	HTTPClient *http.Client
+	// Transport overrides the transport of HTTPClient e.g. to set an outbound proxy.
+	Transport http.RoundTripper
+	// RootCAs holds PEM encoded root certificates to trust in addition to the system roots.
+	RootCAs []byte
+	// UserAgent is appended to the User-Agent header of ACME requests.
+	UserAgent string
+	// Propagation holds the DNS-01 propagation check options of the DNS providers.
+	Propagation *dnsprovider.Propagation
+	// RateLimits are the limits enforced on orders to the CA (default DefaultRateLimits).
+	RateLimits *RateLimits
+	SelfSigned bool
+	// Solvers holds the challenge configuration by exact name or by suffix starting with a dot.
+	Solvers map[string]Solver
+	// ValidateDNS runs ValidateDNSProvider once in CreateConfig.
+	ValidateDNS bool
 }
 
-func (a *ACME) retrieveCertificate(client *acme.Client, account *types.Account) (*tls.Certificate, error) {
+// retrieveCertificate obtains a certificate, marks the order issued and saves the account.
+func (a *ACME) retrieveCertificate(ctx context.Context, client *acme.Client, account *types.Account, order *types.Order) (*tls.Certificate, error) {
 	a.Logger.Println("Retrieving ACME certificate...")
 	domain := []string{}
 	domain = append(domain, a.Domain.Main)
@@ -46,10 +122,14 @@ func (a *ACME) retrieveCertificate(client *acme.Client, account *types.Account)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting ACME certificate for domain %s: %s", domain, err.Error())
 	}
-	if err = account.DomainsCertificate.AddCertificate(certificate, a.Domain); err != nil {
+	order.Issued = true
+	a.mu.Lock()
+	err = account.DomainsCertificate.AddCertificate(certificate, a.Domain)
+	a.mu.Unlock()
+	if err != nil {
 		return nil, fmt.Errorf("Error adding ACME certificate for domain %s: %s", domain, err.Error())
 	}
-	if err = a.backend.SaveAccount(account); err != nil {
+	if err = a.saveAccount(ctx, account); err != nil {
 		return nil, fmt.Errorf("Error Saving ACME account %+v: %s", account, err.Error())
 	}
 	a.Logger.Println("Retrieved ACME certificate")
@@ -63,59 +143,178 @@ func needsUpdate(cert *tls.Certificate) bool {
 		crt, err := x509.ParseCertificate(c)
 		// If there's an error, we assume the cert is broken, and needs update.
 		// <= 7 days left, renew certificate.
-		if err != nil || crt.NotAfter.Before(time.Now().Add(24*7*time.Hour)) {
+		if err != nil || crt.NotAfter.Before(time.Now().Add(renewBefore)) {
 			return true
 		}
 	}
 	return false
 }
 
-func (a *ACME) renewCertificate(client *acme.Client, account *types.Account) error {
+// renewCertificate renews the certificate, marks the order issued and saves the account.
+func (a *ACME) renewCertificate(ctx context.Context, client *acme.Client, account *types.Account, order *types.Order) error {
 	dc := account.DomainsCertificate
-	if needsUpdate(dc.TLSCert) {
-		renewedCert, err := client.RenewCertificate(acme.CertificateResource{
-			Domain:        dc.Certificate.Domain,
-			CertURL:       dc.Certificate.CertURL,
-			CertStableURL: dc.Certificate.CertStableURL,
-			PrivateKey:    dc.Certificate.PrivateKey,
-			Certificate:   dc.Certificate.Cert,
-		}, false)
-		if err != nil {
-			return err
-		}
-		renewedACMECert := &types.Certificate{
-			Domain:        renewedCert.Domain,
-			CertURL:       renewedCert.CertURL,
-			CertStableURL: renewedCert.CertStableURL,
-			PrivateKey:    renewedCert.PrivateKey,
-			Cert:          renewedCert.Certificate,
-		}
-		err = dc.RenewCertificate(renewedACMECert, dc.Domain)
-		if err != nil {
+	renewedCert, err := client.RenewCertificate(acme.CertificateResource{
+		Domain:        dc.Certificate.Domain,
+		CertURL:       dc.Certificate.CertURL,
+		CertStableURL: dc.Certificate.CertStableURL,
+		PrivateKey:    dc.Certificate.PrivateKey,
+		Certificate:   dc.Certificate.Cert,
+	}, false, false)
+	if err != nil {
+		return err
+	}
+	order.Issued = true
+	renewedACMECert := &types.Certificate{
+		Domain:        renewedCert.Domain,
+		CertURL:       renewedCert.CertURL,
+		CertStableURL: renewedCert.CertStableURL,
+		PrivateKey:    renewedCert.PrivateKey,
+		Cert:          renewedCert.Certificate,
+	}
+	a.mu.Lock()
+	err = dc.RenewCertificate(renewedACMECert, dc.Domain)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return a.saveAccount(ctx, account)
+}
+
+// obtainCertificate retrieves a certificate.
+func (a *ACME) obtainCertificate(ctx context.Context) error {
+	a.orderMu.Lock()
+	defer a.orderMu.Unlock()
+	ctx, unlock, _, err := a.lockDomain(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	account := a.loadedAccount()
+	if hasCertificate(account) {
+		// obtained by another replica.
+		return nil
+	}
+	err = a.order(ctx, account, false, func(client *acme.Client, order *types.Order) error {
+		_, err := a.retrieveCertificate(ctx, client, account, order)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error obtaining ACME certificate: %w", err)
+	}
+	return nil
+}
+
+// renewCertificates renews the certificate if needed.
+// When forced, or when a rate limit deferred the first order, a new certificate is obtained with a new private key.
+func (a *ACME) renewCertificates(ctx context.Context, force bool) (err error) {
+	a.orderMu.Lock()
+	defer a.orderMu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.renewalErr = err
+		a.mu.Unlock()
+	}()
+	ctx, unlock, _, err := a.lockDomain(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	account := a.loadedAccount()
+	if force || !hasCertificate(account) {
+		return a.order(ctx, account, hasCertificate(account), func(client *acme.Client, order *types.Order) error {
+			_, err := a.retrieveCertificate(ctx, client, account, order)
 			return err
-		}
-		if err = a.backend.SaveAccount(account); err != nil {
+		})
+	}
+	if !needsUpdate(account.DomainsCertificate.TLSCert) {
+		return nil
+	}
+	return a.order(ctx, account, true, func(client *acme.Client, order *types.Order) error {
+		return a.renewCertificate(ctx, client, account, order)
+	})
+}
+
+// order records the order in the account ledger then calls fn with the client of the CA, which
+// marks the order issued before saving the account.
+func (a *ACME) order(ctx context.Context, account *types.Account, renewal bool, fn func(client *acme.Client, order *types.Order) error) error {
+	client, err := a.acmeClient(ctx, account)
+	if err != nil {
+		return err
+	}
+	dc := account.DomainsCertificate
+	order := &types.Order{
+		Account:  account.Registration.URI,
+		CAServer: a.caServerURL(),
+		Names:    append([]string{dc.Domain.Main}, dc.Domain.SANs...),
+		Renewal:  renewal,
+		Time:     time.Now(),
+	}
+	if err = a.recordOrder(ctx, account, order); err != nil {
+		return err
+	}
+	if err = fn(client, order); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.caServer = order.CAServer
+	a.mu.Unlock()
+	return a.saveAccount(ctx, account)
+}
+
+// loadAccount loads the account from the storage backend within the backend timeout.
+func (a *ACME) loadAccount(ctx context.Context, domain string) (*types.Account, error) {
+	ctx, cancel := a.backendContext(ctx)
+	defer cancel()
+	return a.storage.LoadAccountContext(ctx, domain)
+}
+
+// saveAccount saves the account to the storage backend within the backend timeout. When the
+// stored account was modified by another replica, it is reloaded so that the backend compares
+// against its new revision, merged into the account and the account is saved again.
+func (a *ACME) saveAccount(ctx context.Context, account *types.Account) error {
+	ctx, cancel := a.backendContext(ctx)
+	defer cancel()
+	err := a.storage.SaveAccountContext(ctx, account)
+	for i := 0; i < maxSaveConflicts && errors.Is(err, backend.ErrConflict); i++ {
+		a.Logger.Printf("Account of %q modified concurrently, reloading it before saving again\n", a.Domain.Main)
+		var stored *types.Account
+		if stored, err = a.storage.LoadAccountContext(ctx, account.DomainsCertificate.Domain.Main); err != nil {
 			return err
 		}
+		a.mergeAccount(account, stored)
+		err = a.storage.SaveAccountContext(ctx, account)
 	}
-	return nil
+	return err
 }
 
-func (a *ACME) buildACMEClient(Account *types.Account) (*acme.Client, error) {
-	caServer := defaultCAServer
-	if len(a.CAServer) > 0 {
-		caServer = a.CAServer
+func (a *ACME) backendContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.BackendTimeout > 0 {
+		return context.WithTimeout(ctx, a.BackendTimeout)
 	}
-	client, err := acme.NewClient(caServer, Account, acme.RSA4096)
+	return context.WithCancel(ctx)
+}
+
+func (a *ACME) buildACMEClient(account *types.Account) (*acme.Client, error) {
+	client, err := acme.NewClient(a.caServerURL(), account, certificateKeyType)
 	if err != nil {
 		return nil, err
 	}
+	challenges, err := a.orderChallenges()
+	if err != nil {
+		return nil, err
+	}
+	client.ExcludeChallenges(excludedChallenges(challenges))
+	for _, challenge := range challenges {
+		if err = client.SetChallengeProvider(challenge, a.challengeProvider(challenge)); err != nil {
+			return nil, err
+		}
+	}
 
 	return client, nil
 }
 
 func (a *ACME) getDomainCertificate(client *acme.Client, domains []string) (*types.Certificate, error) {
-	certificate, failures := client.ObtainCertificate(domains, bundleCA, nil)
+	certificate, failures := client.ObtainCertificate(domains, bundleCA, nil, false)
 	if len(failures) > 0 {
 		return nil, fmt.Errorf("Cannot obtain certificates %s+v", failures)
 	}
@@ -131,6 +330,12 @@ func (a *ACME) getDomainCertificate(client *acme.Client, domains []string) (*typ
 
 // CreateConfig creates a tls.config from using ACME configuration
 func (a *ACME) CreateConfig(tlsConfig *tls.Config) error {
+	return a.CreateConfigContext(context.Background(), tlsConfig)
+}
+
+// CreateConfigContext creates a tls.config from using ACME configuration. The context
+// cancels the storage backend operations and stops the renewals when done.
+func (a *ACME) CreateConfigContext(ctx context.Context, tlsConfig *tls.Config) error {
 	if a.Logger == nil {
 		a.Logger = log.New(os.Stdout, "[go-acme] ", log.Ldate|log.Ltime|log.Lshortfile)
 	}
@@ -149,27 +354,70 @@ func (a *ACME) CreateConfig(tlsConfig *tls.Config) error {
 
 	acme.Logger = log.New(ioutil.Discard, "", 0)
 
-	if a.BackendName == "" {
-		a.BackendName = "fs"
+	var err error
+	b := a.Backend
+	if b == nil {
+		if a.BackendName == "" {
+			a.BackendName = "fs"
+		}
+		if b, err = backend.InitBackendWithConfig(a.BackendName, a.BackendConfig); err != nil {
+			return err
+		}
+		if l, ok := b.(backend.LoggerSetter); ok {
+			l.SetLogger(a.Logger)
+		}
+		go func(b backend.Interface) {
+			<-ctx.Done()
+			if err := backend.Close(b); err != nil {
+				a.Logger.Printf("Error closing storage backend %q: %s\n", b.Name(), err.Error())
+			}
+		}(b)
 	}
-	b, err := backend.InitBackend(a.BackendName)
-	if err != nil {
+	a.storage = backend.WithContext(b)
+	a.ctx = ctx
+	if a.instanceID, err = randomToken(); err != nil {
 		return err
 	}
-	a.backend = b
 
-	var account *types.Account
-	var needRegister bool
+	a.client = nil
+	if err = a.initSolvers(); err != nil {
+		return err
+	}
+	if err = a.initChallenges(); err != nil {
+		return err
+	}
+	if _, err = a.orderChallenges(); err != nil {
+		return err
+	}
+	if a.ValidateDNS {
+		if err = a.ValidateDNSProvider(); err != nil {
+			return err
+		}
+	}
+	go func(providers []acme.ChallengeProvider) {
+		<-ctx.Done()
+		a.closeDNSProviders(providers)
+	}(a.openProviders)
+	if a.httpClient, err = a.newHTTPClient(); err != nil {
+		return err
+	}
+	if err = a.routeLegoRequests(ctx); err != nil {
+		return err
+	}
 
 	a.Logger.Println("Loading ACME certificate...")
-	account, err = a.backend.LoadAccount(a.Domain.Main)
+	account, err := a.loadAccount(ctx, a.Domain.Main)
 	if err != nil {
 		return err
 	}
 	if account != nil {
-		a.Logger.Printf("Loaded ACME config from storage %q\n", a.backend.Name())
-		if err = account.DomainsCertificate.Init(); err != nil {
-			return err
+		a.Logger.Printf("Loaded ACME config from storage %q\n", a.storage.Name())
+		account.Logger = a.Logger
+		// the ledger is saved before the first certificate is obtained.
+		if hasCertificate(account) {
+			if err = account.DomainsCertificate.Init(); err != nil {
+				return err
+			}
 		}
 	} else {
 		a.Logger.Println("Generating ACME Account...")
@@ -177,65 +425,90 @@ func (a *ACME) CreateConfig(tlsConfig *tls.Config) error {
 		if err != nil {
 			return err
 		}
-		needRegister = true
 	}
 
-	client, err := a.buildACMEClient(account)
-	if err != nil {
-		return err
-	}
-	client.ExcludeChallenges([]acme.Challenge{acme.HTTP01, acme.TLSSNI01})
-	provider, err := newDNSProvider(a.DNSProvider)
-	if err != nil {
-		return err
-	}
-	client.SetChallengeProvider(acme.DNS01, provider)
+	a.mu.Lock()
+	a.account = account
+	a.caServer = lastIssuer(account)
+	a.mu.Unlock()
+	a.cleanUpJournal(ctx)
 
-	if needRegister {
-		// New users need to register.
-		reg, err := client.Register()
-		if err != nil {
-			return err
+	tlsConfig.GetCertificate = func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if clientHello.ServerName != a.Domain.Main {
+			return nil, errors.New("Unknown server name")
 		}
-		account.Registration = reg
-
-		// The client has a URL to the current Let's Encrypt Subscriber
-		// Agreement. The user needs to agree to it.
-		err = client.AgreeToTOS()
-		if err != nil {
-			return err
+		if cert := a.certificate(); cert != nil {
+			return cert, nil
 		}
+		return nil, errors.New("No certificate loaded for " + clientHello.ServerName)
 	}
 
-	dc := account.DomainsCertificate
-	if len(dc.Certificate.Cert) > 0 && len(dc.Certificate.PrivateKey) > 0 {
-		go func() {
-			if err := a.renewCertificate(client, account); err != nil {
-				a.Logger.Printf("Error renewing ACME certificate for %q: %s\n",
-					account.DomainsCertificate.Domain.Main, err.Error())
-			}
-		}()
-	} else {
-		if _, err := a.retrieveCertificate(client, account); err != nil {
+	a.mu.Lock()
+	a.nextCheck = time.Now().Add(renewInterval)
+	a.mu.Unlock()
+	if hasCertificate(account) && len(account.DomainsCertificate.Certificate.PrivateKey) > 0 {
+		go a.renew()
+		a.Logger.Println("Loaded certificate...")
+	} else if err := a.obtainCertificate(ctx); err != nil {
+		if !a.scheduleRetry(err) {
 			return err
 		}
+		a.Logger.Printf("Error obtaining ACME certificate for %q: %s\n", a.Domain.Main, err.Error())
+	} else {
+		a.Logger.Println("Loaded certificate...")
 	}
-	tlsConfig.GetCertificate = func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		if clientHello.ServerName != a.Domain.Main {
-			return nil, errors.New("Unknown server name")
-		}
-		return dc.TLSCert, nil
-	}
-	a.Logger.Println("Loaded certificate...")
 
-	ticker := time.NewTicker(24 * time.Hour)
+	ticker := time.NewTicker(renewInterval)
 	go func() {
-		for range ticker.C {
-			if err := a.renewCertificate(client, account); err != nil {
-				a.Logger.Printf("Error renewing ACME certificate %q: %s\n",
-					account.DomainsCertificate.Domain.Main, err.Error())
+		for {
+			select {
+			case <-ticker.C:
+				a.renew()
+			case <-ctx.Done():
+				ticker.Stop()
+				a.mu.Lock()
+				if a.retry != nil {
+					a.retry.Stop()
+				}
+				a.mu.Unlock()
+				return
 			}
 		}
 	}()
-	return nil
+	return a.watch(ctx)
+}
+
+// renew renews the certificate if needed and records the outcome.
+func (a *ACME) renew() {
+	if a.ctx.Err() != nil {
+		return
+	}
+	err := a.renewCertificates(a.ctx, false)
+	a.mu.Lock()
+	a.nextCheck = time.Now().Add(renewInterval)
+	a.mu.Unlock()
+	if err != nil {
+		a.Logger.Printf("Error renewing ACME certificate for %q: %s\n", a.Domain.Main, err.Error())
+		a.scheduleRetry(err)
+	}
+}
+
+// scheduleRetry schedules a renewal at the end of the window of the rate limit exceeded,
+// returning false if err is not a *RateLimitError.
+func (a *ACME) scheduleRetry(err error) bool {
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.retry != nil {
+		a.retry.Stop()
+	}
+	a.retry = time.AfterFunc(time.Until(rateLimitErr.RetryAfter), a.renew)
+	if rateLimitErr.RetryAfter.Before(a.nextCheck) {
+		a.nextCheck = rateLimitErr.RetryAfter
+	}
+	a.Logger.Printf("Retrying ACME order for %q at %s\n", a.Domain.Main, rateLimitErr.RetryAfter.Format(time.RFC3339))
+	return true
 }
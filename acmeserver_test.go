@@ -0,0 +1,392 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/types"
+)
+
+func init() {
+	// smaller certificate keys keep the tests fast.
+	certificateKeyType = acme.RSA2048
+}
+
+// testACMEServer is a stand-in ACME v1 CA registering any account. Challenges are validated
+// with the validate function and certificates are signed by a throwaway root.
+type testACMEServer struct {
+	*httptest.Server
+	t        *testing.T
+	mu       sync.Mutex
+	validate func(challengeType, domain, token, keyAuth string) bool
+	authzs   []*testAuthz
+	// newAuthzs counts the new-authz requests by domain.
+	newAuthzs map[string]int
+	certs     int
+	// userAgents holds the User-Agent headers of the requests.
+	userAgents []string
+	caKey      *rsa.PrivateKey
+	caCert     *x509.Certificate
+}
+
+type testAuthz struct {
+	Identifier     testIdentifier   `json:"identifier"`
+	Status         string           `json:"status"`
+	Challenges     []*testChallenge `json:"challenges"`
+	Combinations   [][]int          `json:"combinations"`
+	validatedTypes []string
+}
+
+type testIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type testChallenge struct {
+	Type             string `json:"type"`
+	Status           string `json:"status"`
+	URI              string `json:"uri"`
+	Token            string `json:"token"`
+	KeyAuthorization string `json:"keyAuthorization,omitempty"`
+}
+
+func newTestACMEServer(t *testing.T) *testACMEServer {
+	s := newUnstartedTestACMEServer(t)
+	s.Start()
+	return s
+}
+
+// newTestTLSACMEServer starts the stand-in CA over TLS and returns the PEM encoded server certificate.
+func newTestTLSACMEServer(t *testing.T) (*testACMEServer, []byte) {
+	s := newUnstartedTestACMEServer(t)
+	s.StartTLS()
+	return s, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+}
+
+func newUnstartedTestACMEServer(t *testing.T) *testACMEServer {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stand-in root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testACMEServer{
+		t:         t,
+		newAuthzs: make(map[string]int),
+		caKey:     caKey,
+		caCert:    caCert,
+		validate: func(challengeType, domain, token, keyAuth string) bool {
+			return true
+		},
+	}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testACMEServer) directory() string {
+	return s.URL + "/directory"
+}
+
+func (s *testACMEServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userAgents = append(s.userAgents, r.UserAgent())
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	switch path := r.URL.Path; {
+	case path == "/directory":
+		writeTestJSON(w, http.StatusOK, map[string]string{
+			"new-reg":     s.URL + "/new-reg",
+			"new-authz":   s.URL + "/new-authz",
+			"new-cert":    s.URL + "/new-cert",
+			"revoke-cert": s.URL + "/revoke-cert",
+		})
+	case path == "/new-reg":
+		key := s.decodePayload(r, &struct{}{})
+		w.Header().Set("Location", s.URL+"/reg/1")
+		w.Header().Add("Link", fmt.Sprintf("<%s/new-authz>;rel=\"next\"", s.URL))
+		w.Header().Add("Link", fmt.Sprintf("<%s/terms>;rel=\"terms-of-service\"", s.URL))
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"id": 1, "key": key})
+	case strings.HasPrefix(path, "/reg/"):
+		key := s.decodePayload(r, &struct{}{})
+		writeTestJSON(w, http.StatusAccepted, map[string]interface{}{"id": 1, "key": key})
+	case path == "/new-authz":
+		var payload struct {
+			Identifier testIdentifier `json:"identifier"`
+		}
+		s.decodePayload(r, &payload)
+		s.newAuthzs[payload.Identifier.Value]++
+		s.writeAuthz(w, http.StatusCreated, s.authorization(payload.Identifier))
+	case strings.HasPrefix(path, "/authz/"):
+		var id int
+		fmt.Sscanf(path, "/authz/%d", &id)
+		s.writeAuthz(w, http.StatusOK, id)
+	case strings.HasPrefix(path, "/challenge/"):
+		var id, index int
+		fmt.Sscanf(path, "/challenge/%d/%d", &id, &index)
+		authz := s.authzs[id]
+		challenge := authz.Challenges[index]
+		if r.Method == http.MethodPost {
+			var payload struct {
+				KeyAuthorization string `json:"keyAuthorization"`
+			}
+			s.decodePayload(r, &payload)
+			challenge.KeyAuthorization = payload.KeyAuthorization
+			challenge.Status = "invalid"
+			authz.Status = "invalid"
+			if s.validate(challenge.Type, authz.Identifier.Value, challenge.Token, payload.KeyAuthorization) {
+				challenge.Status = "valid"
+				authz.Status = "valid"
+				authz.validatedTypes = append(authz.validatedTypes, challenge.Type)
+			}
+			writeTestJSON(w, http.StatusAccepted, challenge)
+			return
+		}
+		writeTestJSON(w, http.StatusAccepted, challenge)
+	case path == "/new-cert":
+		var payload struct {
+			CSR string `json:"csr"`
+		}
+		s.decodePayload(r, &payload)
+		der, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(payload.CSR, "="))
+		if err != nil {
+			s.t.Errorf("Invalid CSR encoding: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cert, err := s.sign(der)
+		if err != nil {
+			s.t.Errorf("Error signing CSR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.certs++
+		w.Header().Set("Location", fmt.Sprintf("%s/cert/%d", s.URL, s.certs))
+		w.Header().Add("Link", fmt.Sprintf("<%s/issuer>;rel=\"up\"", s.URL))
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(cert)
+	case path == "/issuer":
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.Write(s.caCert.Raw)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorization returns the id of the valid authorization of the identifier if any,
+// or of a new pending authorization offering the http-01, dns-01 and tls-alpn-01 challenges.
+func (s *testACMEServer) authorization(identifier testIdentifier) int {
+	for id, authz := range s.authzs {
+		if authz.Identifier == identifier && authz.Status == "valid" {
+			return id
+		}
+	}
+	id := len(s.authzs)
+	authz := &testAuthz{Identifier: identifier, Status: "pending", Combinations: [][]int{{0}, {1}, {2}}}
+	for i, challengeType := range []string{"http-01", "dns-01", "tls-alpn-01"} {
+		authz.Challenges = append(authz.Challenges, &testChallenge{
+			Type:   challengeType,
+			Status: "pending",
+			URI:    fmt.Sprintf("%s/challenge/%d/%d", s.URL, id, i),
+			Token:  fmt.Sprintf("token-%d-%d", id, i),
+		})
+	}
+	s.authzs = append(s.authzs, authz)
+	return id
+}
+
+func (s *testACMEServer) writeAuthz(w http.ResponseWriter, status, id int) {
+	if id < 0 || id >= len(s.authzs) {
+		http.NotFound(w, nil)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/authz/%d", s.URL, id))
+	w.Header().Add("Link", fmt.Sprintf("<%s/new-cert>;rel=\"next\"", s.URL))
+	writeTestJSON(w, status, s.authzs[id])
+}
+
+// validatedTypes returns the challenge types validated for the domain.
+func (s *testACMEServer) validatedTypes(domain string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var validated []string
+	for _, authz := range s.authzs {
+		if authz.Identifier.Value == domain {
+			validated = append(validated, authz.validatedTypes...)
+		}
+	}
+	return validated
+}
+
+func (s *testACMEServer) sign(der []byte) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	// like public CAs, the common name is included in the names.
+	names := csr.DNSNames
+	if cn := csr.Subject.CommonName; cn != "" && (len(names) == 0 || names[0] != cn) {
+		names = append([]string{cn}, names...)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(s.certs + 2)),
+		Subject:      csr.Subject,
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+}
+
+// decodePayload decodes the payload of the JWS request, checking the signature of the
+// requests signed with RS256 and an embedded key. It returns the key of the request.
+func (s *testACMEServer) decodePayload(r *http.Request, v interface{}) json.RawMessage {
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		s.t.Errorf("Invalid JWS request to %s: %v", r.URL.Path, err)
+		return nil
+	}
+	if err := verifyTestJWS(jws.Protected, jws.Payload, jws.Signature); err != nil {
+		s.t.Errorf("Invalid JWS signature of request to %s: %v", r.URL.Path, err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		s.t.Errorf("Invalid JWS payload to %s: %v", r.URL.Path, err)
+		return nil
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		s.t.Errorf("Invalid payload to %s: %v", r.URL.Path, err)
+	}
+	var header struct {
+		JWK json.RawMessage `json:"jwk"`
+	}
+	if protected, err := base64.RawURLEncoding.DecodeString(jws.Protected); err == nil {
+		json.Unmarshal(protected, &header)
+	}
+	return header.JWK
+}
+
+func verifyTestJWS(protected, payload, signature string) error {
+	data, err := base64.RawURLEncoding.DecodeString(protected)
+	if err != nil {
+		return err
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		JWK *struct {
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"jwk"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	if header.Alg != "RS256" || header.JWK == nil || header.JWK.Kty != "RSA" {
+		return nil
+	}
+	n, err := base64.RawURLEncoding.DecodeString(header.JWK.N)
+	if err != nil {
+		return err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(header.JWK.E)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	digest := sha256.Sum256([]byte(protected + "." + payload))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// memoryBackend is a storage backend keeping the accounts in memory.
+type memoryBackend struct {
+	mu       sync.Mutex
+	accounts map[string][]byte
+}
+
+func (m *memoryBackend) Name() string {
+	return "memory"
+}
+
+func (m *memoryBackend) SaveAccount(account *types.Account) error {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.accounts == nil {
+		m.accounts = make(map[string][]byte)
+	}
+	m.accounts[account.DomainsCertificate.Domain.Main] = data
+	return nil
+}
+
+func (m *memoryBackend) LoadAccount(domain string) (*types.Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, found := m.accounts[domain]
+	if !found {
+		return nil, nil
+	}
+	account := &types.Account{DomainsCertificate: &types.DomainCertificate{}}
+	if err := json.Unmarshal(data, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
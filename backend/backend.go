@@ -18,18 +18,41 @@ package backend
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"sync"
 
+	"github.com/jtblin/go-logger"
+
 	"github.com/jtblin/go-acme/types"
 )
 
 // All registered backends.
 var backendsMutex sync.Mutex
-var backends = make(map[string]Factory)
+var backends = make(map[string]ConfigFactory)
 
-// Factory is a function that returns a backend.Interface.
+// Factory is a function that returns a backend.Interface configured with environment variables.
 type Factory func() (Interface, error)
 
+// ConfigFactory is a function that returns a backend.Interface configured with config,
+// falling back to environment variables for unset values. The config may be nil.
+type ConfigFactory func(config Config) (Interface, error)
+
+// Config holds the configuration of a backend by key e.g. "bucket".
+type Config map[string]string
+
+// Get returns the value for the key, or the value of the environment variable
+// if the value is not set.
+func (c Config) Get(key, env string) string {
+	if value, found := c[key]; found && value != "" {
+		return value
+	}
+	if env == "" {
+		return ""
+	}
+	return os.Getenv(env)
+}
+
 // Interface represents a backend.
 type Interface interface {
 	// LoadAccount loads the account from the backend store.
@@ -40,8 +63,31 @@ type Interface interface {
 	SaveAccount(*types.Account) error
 }
 
-// RegisterBackend registers a backend.
+// LoggerSetter is implemented by backends logging errors in the background
+// e.g. of the token renewals, whose logger is set to ACME.Logger.
+type LoggerSetter interface {
+	// SetLogger sets the logger of the backend.
+	SetLogger(logger logger.Interface)
+}
+
+// Close releases the resources held by the backend e.g. the token renewal of vault,
+// if it implements io.Closer.
+func Close(backend Interface) error {
+	if c, ok := backend.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// RegisterBackend registers a backend configured with environment variables.
 func RegisterBackend(name string, backend Factory) {
+	RegisterConfigBackend(name, func(Config) (Interface, error) {
+		return backend()
+	})
+}
+
+// RegisterConfigBackend registers a backend configured with a Config.
+func RegisterConfigBackend(name string, backend ConfigFactory) {
 	backendsMutex.Lock()
 	defer backendsMutex.Unlock()
 	if _, found := backends[name]; found {
@@ -54,17 +100,28 @@ func RegisterBackend(name string, backend Factory) {
 // the name is not known.  The error return is only used if the named provider
 // was known but failed to initialize.
 func GetBackend(name string) (Interface, error) {
+	return GetBackendWithConfig(name, nil)
+}
+
+// GetBackendWithConfig creates an instance of the named backend configured with config,
+// or nil if the name is not known.
+func GetBackendWithConfig(name string, config Config) (Interface, error) {
 	backendsMutex.Lock()
 	defer backendsMutex.Unlock()
 	f, found := backends[name]
 	if !found {
 		return nil, nil
 	}
-	return f()
+	return f(config)
 }
 
 // InitBackend creates an instance of the named backend.
 func InitBackend(name string) (Interface, error) {
+	return InitBackendWithConfig(name, nil)
+}
+
+// InitBackendWithConfig creates an instance of the named backend configured with config.
+func InitBackendWithConfig(name string, config Config) (Interface, error) {
 	var backend Interface
 	var err error
 
@@ -72,7 +129,7 @@ func InitBackend(name string) (Interface, error) {
 		return nil, nil
 	}
 
-	backend, err = GetBackend(name)
+	backend, err = GetBackendWithConfig(name, config)
 	if err != nil {
 		return nil, fmt.Errorf("Could not init backend %q: %v", name, err)
 	}
@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+)
+
+// testConfigBackend is a backend recording the config it was created with.
+type testConfigBackend struct {
+	testBackend
+	config Config
+}
+
+func TestRegisterConfigBackend(t *testing.T) {
+	RegisterConfigBackend("test-config", func(config Config) (Interface, error) {
+		return &testConfigBackend{config: config}, nil
+	})
+	b, err := InitBackendWithConfig("test-config", Config{"bucket": "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket := b.(*testConfigBackend).config.Get("bucket", ""); bucket != "first" {
+		t.Errorf("Expected the backend configured with the bucket of the config, got %q", bucket)
+	}
+	// each instance has its own config.
+	other, err := InitBackendWithConfig("test-config", Config{"bucket": "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket := other.(*testConfigBackend).config.Get("bucket", ""); bucket != "second" {
+		t.Errorf("Expected the other backend configured with its own bucket, got %q", bucket)
+	}
+}
+
+func TestRegisterBackend(t *testing.T) {
+	created := 0
+	RegisterBackend("test-legacy", func() (Interface, error) {
+		created++
+		return &testBackend{}, nil
+	})
+	// the config is ignored by the backends configured with environment variables.
+	for _, config := range []Config{nil, {"bucket": "ignored"}} {
+		b, err := InitBackendWithConfig("test-legacy", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := b.(*testBackend); !ok {
+			t.Errorf("Expected the legacy backend, got %T", b)
+		}
+	}
+	if created != 2 {
+		t.Errorf("Expected the legacy factory called for each instance, got %d", created)
+	}
+}
+
+func TestUnknownBackend(t *testing.T) {
+	if b, err := GetBackendWithConfig("test-unknown", Config{}); b != nil || err != nil {
+		t.Errorf("Expected no backend without error, got %v, %v", b, err)
+	}
+	if _, err := InitBackendWithConfig("test-unknown", Config{}); err == nil || !strings.HasPrefix(err.Error(), `Unknown backend "test-unknown"`) {
+		t.Errorf("Expected an unknown backend error, got %v", err)
+	}
+	if b, err := InitBackend(""); b != nil || err != nil {
+		t.Errorf("Expected no backend without name, got %v, %v", b, err)
+	}
+}
+
+func TestConfigGet(t *testing.T) {
+	t.Setenv("TEST_BACKEND_BUCKET", "env")
+	tests := []struct {
+		config   Config
+		env      string
+		expected string
+	}{
+		{Config{"bucket": "config"}, "TEST_BACKEND_BUCKET", "config"},
+		{Config{"bucket": ""}, "TEST_BACKEND_BUCKET", "env"},
+		{nil, "TEST_BACKEND_BUCKET", "env"},
+		{nil, "", ""},
+	}
+	for _, test := range tests {
+		if value := test.config.Get("bucket", test.env); value != test.expected {
+			t.Errorf("Expected %q for config %v and env %q, got %q", test.expected, test.config, test.env, value)
+		}
+	}
+}
@@ -18,7 +18,12 @@ package backends
 
 import (
 	// initialise all backends.
+	_ "github.com/jtblin/go-acme/backend/backends/consul"
+	_ "github.com/jtblin/go-acme/backend/backends/etcd"
 	_ "github.com/jtblin/go-acme/backend/backends/fs"
+	_ "github.com/jtblin/go-acme/backend/backends/kubernetes"
 	_ "github.com/jtblin/go-acme/backend/backends/null"
+	_ "github.com/jtblin/go-acme/backend/backends/redis"
 	_ "github.com/jtblin/go-acme/backend/backends/s3"
+	_ "github.com/jtblin/go-acme/backend/backends/vault"
 )
@@ -0,0 +1,271 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+const (
+	backendName     = "consul"
+	prefixEnv       = "CONSUL_PREFIX"
+	lockTTLEnv      = "CONSUL_LOCK_TTL"
+	defaultPrefix   = "go-acme/"
+	defaultLockTTL  = "60s"
+	lockSessionName = "go-acme"
+	// staleIndexError is reported by consul when the index of a check-and-set is not the modify index of the key.
+	staleIndexError = "index is stale"
+)
+
+// storage stores the accounts in the consul KV store and implements the backend.ContextInterface,
+// backend.Deleter and backend.Locker interfaces.
+type storage struct {
+	client  *api.Client
+	prefix  string
+	lockTTL string
+	// indexes holds the modify index of the accounts last loaded or saved by domain,
+	// saves are checked and set against it.
+	indexes     map[string]uint64
+	indexesLock sync.Mutex
+}
+
+// Name returns the display name of the backend.
+func (s *storage) Name() string {
+	return backendName
+}
+
+func (s *storage) key(domain string) string {
+	return s.prefix + "accounts/" + domain
+}
+
+func (s *storage) index(domain string) uint64 {
+	s.indexesLock.Lock()
+	defer s.indexesLock.Unlock()
+	return s.indexes[domain]
+}
+
+func (s *storage) setIndex(domain string, index uint64) {
+	s.indexesLock.Lock()
+	defer s.indexesLock.Unlock()
+	s.indexes[domain] = index
+}
+
+// SaveAccount saves the account to consul.
+func (s *storage) SaveAccount(account *types.Account) error {
+	return s.SaveAccountContext(context.Background(), account)
+}
+
+// SaveAccountContext saves the account to consul if it was not modified since it was last
+// loaded or saved, and returns backend.ErrConflict otherwise.
+func (s *storage) SaveAccountContext(ctx context.Context, account *types.Account) error {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	domain := account.DomainsCertificate.Domain.Main
+	ops := api.KVTxnOps{&api.KVTxnOp{
+		Verb:  api.KVCAS,
+		Key:   s.key(domain),
+		Value: data,
+		Index: s.index(domain),
+	}}
+	ok, resp, _, err := s.client.KV().Txn(ops, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return txnError(resp.Errors)
+	}
+	if len(resp.Results) == 0 || resp.Results[0] == nil {
+		return errors.New("Consul transaction returned no result")
+	}
+	s.setIndex(domain, resp.Results[0].ModifyIndex)
+	return nil
+}
+
+// txnError returns backend.ErrConflict if the transaction was rolled back by a failed
+// check-and-set, or the errors of the operations otherwise e.g. an ACL "Permission denied".
+func txnError(errs api.TxnErrors) error {
+	var whats []string
+	for _, err := range errs {
+		if strings.Contains(err.What, staleIndexError) {
+			return backend.ErrConflict
+		}
+		whats = append(whats, err.What)
+	}
+	if len(whats) == 0 {
+		return errors.New("Consul transaction rolled back")
+	}
+	return fmt.Errorf("Consul transaction failed: %s", strings.Join(whats, ", "))
+}
+
+// LoadAccount loads the account from consul.
+func (s *storage) LoadAccount(domain string) (*types.Account, error) {
+	return s.LoadAccountContext(context.Background(), domain)
+}
+
+// LoadAccountContext loads the account from consul.
+func (s *storage) LoadAccountContext(ctx context.Context, domain string) (*types.Account, error) {
+	pair, _, err := s.client.KV().Get(s.key(domain), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		s.setIndex(domain, 0)
+		return nil, nil
+	}
+	account := types.Account{
+		DomainsCertificate: &types.DomainCertificate{},
+	}
+	if err := json.Unmarshal(pair.Value, &account); err != nil {
+		return nil, fmt.Errorf("Error loading account: %v", err)
+	}
+	s.setIndex(domain, pair.ModifyIndex)
+	return &account, nil
+}
+
+// DeleteAccount deletes the account from consul.
+func (s *storage) DeleteAccount(domain string) error {
+	if _, err := s.client.KV().Delete(s.key(domain), nil); err != nil {
+		return err
+	}
+	s.setIndex(domain, 0)
+	return nil
+}
+
+// Lock acquires the lock of the domain, held with a consul session which is invalidated
+// if the process dies and destroyed on unlock. The context returned is done if the session
+// is invalidated.
+func (s *storage) Lock(ctx context.Context, domain string) (context.Context, func() error, error) {
+	session := s.client.Session()
+	options := (&api.WriteOptions{}).WithContext(ctx)
+	id, _, err := session.Create(&api.SessionEntry{Name: lockSessionName, TTL: s.lockTTL}, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	renew := make(chan struct{})
+	go session.RenewPeriodic(s.lockTTL, id, &api.WriteOptions{}, renew)
+	// destroy stops the renewal and destroys the session rather than waiting for its TTL.
+	var destroyOnce sync.Once
+	destroy := func() (err error) {
+		destroyOnce.Do(func() {
+			close(renew)
+			_, err = session.Destroy(id, &api.WriteOptions{})
+		})
+		return err
+	}
+
+	lock, err := s.client.LockOpts(&api.LockOptions{Key: s.prefix + "locks/" + domain, Session: id})
+	if err != nil {
+		destroy()
+		return nil, nil, err
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stop)
+		case <-done:
+		}
+	}()
+	lost, err := lock.Lock(stop)
+	if err == nil && lost == nil {
+		if err = ctx.Err(); err == nil {
+			err = fmt.Errorf("Lock of %q not acquired", domain)
+		}
+	}
+	if err != nil {
+		destroy()
+		return nil, nil, err
+	}
+	locked, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-locked.Done():
+		}
+	}()
+	return locked, func() error {
+		cancel()
+		err := lock.Unlock()
+		if destroyErr := destroy(); err == nil {
+			err = destroyErr
+		}
+		return err
+	}, nil
+}
+
+func newBackend(config backend.Config) (backend.Interface, error) {
+	// the consul client reads CONSUL_HTTP_ADDR, CONSUL_HTTP_TOKEN, CONSUL_CACERT etc.
+	consulConfig := api.DefaultConfig()
+	if address := config.Get("address", ""); address != "" {
+		consulConfig.Address = address
+	}
+	if scheme := config.Get("scheme", ""); scheme != "" {
+		consulConfig.Scheme = scheme
+	}
+	if token := config.Get("token", ""); token != "" {
+		consulConfig.Token = token
+	}
+	if datacenter := config.Get("datacenter", ""); datacenter != "" {
+		consulConfig.Datacenter = datacenter
+	}
+	if caFile := config.Get("ca_file", ""); caFile != "" {
+		consulConfig.TLSConfig.CAFile = caFile
+	}
+	if certFile := config.Get("cert_file", ""); certFile != "" {
+		consulConfig.TLSConfig.CertFile = certFile
+	}
+	if keyFile := config.Get("key_file", ""); keyFile != "" {
+		consulConfig.TLSConfig.KeyFile = keyFile
+	}
+	if insecure := config.Get("insecure_skip_verify", ""); insecure != "" {
+		skip, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid consul insecure_skip_verify %q: %v", insecure, err)
+		}
+		consulConfig.TLSConfig.InsecureSkipVerify = skip
+	}
+
+	prefix := config.Get("prefix", prefixEnv)
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	prefix = strings.TrimPrefix(prefix, "/")
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	lockTTL := config.Get("lock_ttl", lockTTLEnv)
+	if lockTTL == "" {
+		lockTTL = defaultLockTTL
+	}
+
+	client, err := api.NewClient(consulConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &storage{
+		client:  client,
+		prefix:  prefix,
+		lockTTL: lockTTL,
+		indexes: make(map[string]uint64),
+	}, nil
+}
+
+func init() {
+	backend.RegisterConfigBackend(backendName, func(config backend.Config) (backend.Interface, error) {
+		return newBackend(config)
+	})
+}
@@ -0,0 +1,307 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/backend/backendtest"
+)
+
+// testConsul is a fake consul HTTP API serving the KV store, the KV transactions and the sessions.
+// The requests with the denied token fail the ACL checks like consul does.
+type testConsul struct {
+	*httptest.Server
+	mu          sync.Mutex
+	index       uint64
+	pairs       map[string]*api.KVPair
+	sessions    int
+	live        map[string]bool
+	deniedToken string
+}
+
+func newTestConsul(t *testing.T) *testConsul {
+	c := &testConsul{pairs: make(map[string]*api.KVPair), live: make(map[string]bool), deniedToken: "denied"}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.serve))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *testConsul) serve(w http.ResponseWriter, r *http.Request) {
+	if index := r.URL.Query().Get("index"); index != "" {
+		c.block(index)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	denied := r.Header.Get("X-Consul-Token") == c.deniedToken
+
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/txn":
+		var ops api.TxnOps
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var resp api.TxnResponse
+		for i, op := range ops {
+			if err := c.apply(op.KV, denied); err != nil {
+				resp.Errors = append(resp.Errors, &api.TxnError{OpIndex: i, What: err.Error()})
+				continue
+			}
+			resp.Results = append(resp.Results, &api.TxnResult{KV: c.pairs[op.KV.Key]})
+		}
+		if len(resp.Errors) > 0 {
+			resp.Results = nil
+			w.WriteHeader(http.StatusConflict)
+		}
+		json.NewEncoder(w).Encode(resp)
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/session/create":
+		c.sessions++
+		id := fmt.Sprintf("session-%d", c.sessions)
+		c.live[id] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/")
+		delete(c.live, id)
+		for _, pair := range c.pairs {
+			if pair.Session == id {
+				c.index++
+				pair.Session, pair.ModifyIndex = "", c.index
+			}
+		}
+		json.NewEncoder(w).Encode(true)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+		json.NewEncoder(w).Encode([]*api.SessionEntry{{ID: strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")}})
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		if denied {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		switch r.Method {
+		case http.MethodGet:
+			pair, found := c.pairs[key]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode([]*api.KVPair{pair})
+		case http.MethodDelete:
+			delete(c.pairs, key)
+			fmt.Fprint(w, "true")
+		case http.MethodPut:
+			fmt.Fprint(w, c.lock(key, r.URL.Query()))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// block holds a blocking query until the index changes or the wait time elapses.
+func (c *testConsul) block(index string) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		c.mu.Lock()
+		changed := strconv.FormatUint(c.index, 10) != index
+		c.mu.Unlock()
+		if changed {
+			return
+		}
+	}
+}
+
+// lock acquires or releases the lock key for the session of the query like consul does.
+func (c *testConsul) lock(key string, query url.Values) bool {
+	pair, found := c.pairs[key]
+	if session := query.Get("acquire"); session != "" {
+		if found && pair.Session != "" && pair.Session != session {
+			return false
+		}
+		flags, _ := strconv.ParseUint(query.Get("flags"), 10, 64)
+		c.index++
+		c.pairs[key] = &api.KVPair{Key: key, Flags: flags, Session: session, ModifyIndex: c.index, LockIndex: 1}
+		return true
+	}
+	if session := query.Get("release"); session != "" && found && pair.Session == session {
+		c.index++
+		pair.Session, pair.ModifyIndex = "", c.index
+		return true
+	}
+	return false
+}
+
+// liveSessions returns the number of sessions not destroyed.
+func (c *testConsul) liveSessions() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.live)
+}
+
+// invalidate invalidates the session holding the lock key e.g. after its TTL expired.
+func (c *testConsul) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pair, found := c.pairs[key]; found {
+		c.index++
+		pair.Session, pair.ModifyIndex = "", c.index
+	}
+}
+
+// apply applies the check-and-set operation like the consul state store.
+func (c *testConsul) apply(op *api.KVTxnOp, denied bool) error {
+	if denied {
+		return fmt.Errorf("Permission denied")
+	}
+	if op.Verb != api.KVCAS {
+		return fmt.Errorf("unsupported verb %q", op.Verb)
+	}
+	existing, found := c.pairs[op.Key]
+	if (!found && op.Index != 0) || (found && existing.ModifyIndex != op.Index) {
+		return fmt.Errorf("failed to set key %q, index is stale", op.Key)
+	}
+	c.index++
+	pair := &api.KVPair{Key: op.Key, Value: op.Value, ModifyIndex: c.index, CreateIndex: c.index}
+	if found {
+		pair.CreateIndex = existing.CreateIndex
+	}
+	c.pairs[op.Key] = pair
+	return nil
+}
+
+func newTestStorage(t *testing.T, c *testConsul, token string) *storage {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newBackend(backend.Config{"address": u.Host, "scheme": "http", "token": token, "prefix": "/test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*storage)
+}
+
+func TestBackend(t *testing.T) {
+	c := newTestConsul(t)
+	backendtest.TestBackend(t, func(t *testing.T) backend.Interface {
+		return newTestStorage(t, c, "")
+	})
+}
+
+func TestPrefix(t *testing.T) {
+	c := newTestConsul(t)
+	s := newTestStorage(t, c, "")
+	if err := s.SaveAccount(backendtest.Account("www.example.com", "user@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := c.pairs["test/accounts/www.example.com"]; !found {
+		t.Errorf("Expected the account to be stored under the prefix, got %v", c.pairs)
+	}
+}
+
+func TestSavePermissionDenied(t *testing.T) {
+	c := newTestConsul(t)
+	s := newTestStorage(t, c, c.deniedToken)
+	err := s.SaveAccount(backendtest.Account("www.example.com", "user@example.com"))
+	if err == nil || err == backend.ErrConflict || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("Expected the permission denied error, got %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	c := newTestConsul(t)
+	first, second := newTestStorage(t, c, ""), newTestStorage(t, c, "")
+	_, unlock, err := first.Lock(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, _, err := second.Lock(ctx, "www.example.com"); err == nil {
+		t.Fatal("Expected the lock to be held by the first node")
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, unlock, err = second.Lock(ctx, "www.example.com")
+	if err != nil {
+		t.Fatalf("Expected the lock to be acquired once released, got %v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if live := c.liveSessions(); live != 0 {
+		t.Errorf("Expected the sessions to be destroyed, got %d sessions", live)
+	}
+}
+
+func TestLockNotAcquired(t *testing.T) {
+	c := newTestConsul(t)
+	s := newTestStorage(t, c, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if locked, unlock, err := s.Lock(ctx, "www.example.com"); err == nil || locked != nil || unlock != nil {
+		t.Errorf("Expected an error locking with a canceled context, got %v", err)
+	}
+	if live := c.liveSessions(); live != 0 {
+		t.Errorf("Expected the session to be destroyed, got %d sessions", live)
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	c := newTestConsul(t)
+	s := newTestStorage(t, c, "")
+	locked, unlock, err := s.Lock(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	select {
+	case <-locked.Done():
+		t.Fatal("Expected the context of the operations not done while the lock is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.invalidate("test/locks/www.example.com")
+	select {
+	case <-locked.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the context of the operations done once the lock is lost")
+	}
+}
+
+func TestTxnError(t *testing.T) {
+	tests := []struct {
+		errs     api.TxnErrors
+		conflict bool
+		message  string
+	}{
+		{api.TxnErrors{{What: `failed to set key "go-acme/accounts/www.example.com", index is stale`}}, true, ""},
+		{api.TxnErrors{{What: "Permission denied"}}, false, "Permission denied"},
+		{api.TxnErrors{{What: "Permission denied"}, {OpIndex: 1, What: "invalid session"}}, false, "Permission denied, invalid session"},
+		{nil, false, "rolled back"},
+	}
+	for _, test := range tests {
+		err := txnError(test.errs)
+		if (err == backend.ErrConflict) != test.conflict || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Unexpected error %v for %v", err, test.errs)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+const (
+	backendName        = "etcd"
+	envPrefix          = "ETCD_"
+	endpointsEnv       = "ETCD_ENDPOINTS"
+	prefixEnv          = "ETCD_PREFIX"
+	usernameEnv        = "ETCD_USERNAME"
+	passwordEnv        = "ETCD_PASSWORD"
+	dialTimeoutEnv     = "ETCD_DIAL_TIMEOUT"
+	lockTTLEnv         = "ETCD_LOCK_TTL"
+	defaultEndpoints   = "127.0.0.1:2379"
+	defaultPrefix      = "/go-acme/"
+	defaultDialTimeout = 5 * time.Second
+	defaultLockTTL     = 60
+)
+
+// storage stores the accounts in etcd and implements the backend.ContextInterface,
+// backend.Deleter, backend.Locker, backend.Watcher and io.Closer interfaces.
+type storage struct {
+	client  *clientv3.Client
+	prefix  string
+	lockTTL int
+	// revisions holds the mod revision of the accounts last loaded or saved by domain,
+	// saves are compared and swapped against it.
+	revisions map[string]int64
+	// saved holds the data of the accounts last saved by domain, set before the save so that
+	// the watches ignore it even if notified before the response of the save.
+	saved         map[string][]byte
+	revisionsLock sync.Mutex
+}
+
+// Name returns the display name of the backend.
+func (s *storage) Name() string {
+	return backendName
+}
+
+// Close closes the etcd client.
+func (s *storage) Close() error {
+	return s.client.Close()
+}
+
+func (s *storage) key(domain string) string {
+	return s.prefix + "accounts/" + domain
+}
+
+func (s *storage) revision(domain string) int64 {
+	s.revisionsLock.Lock()
+	defer s.revisionsLock.Unlock()
+	return s.revisions[domain]
+}
+
+func (s *storage) setRevision(domain string, revision int64) {
+	s.revisionsLock.Lock()
+	defer s.revisionsLock.Unlock()
+	s.revisions[domain] = revision
+}
+
+func (s *storage) setSaved(domain string, data []byte) {
+	s.revisionsLock.Lock()
+	defer s.revisionsLock.Unlock()
+	s.saved[domain] = data
+}
+
+// ownChange returns true if the event is the save of the account by this replica.
+func (s *storage) ownChange(domain string, kv *mvccpb.KeyValue) bool {
+	s.revisionsLock.Lock()
+	defer s.revisionsLock.Unlock()
+	return kv.ModRevision == s.revisions[domain] || bytes.Equal(kv.Value, s.saved[domain])
+}
+
+// SaveAccount saves the account to etcd.
+func (s *storage) SaveAccount(account *types.Account) error {
+	return s.SaveAccountContext(context.Background(), account)
+}
+
+// SaveAccountContext saves the account to etcd if it was not modified since it was last
+// loaded or saved, and returns backend.ErrConflict otherwise.
+func (s *storage) SaveAccountContext(ctx context.Context, account *types.Account) error {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	domain := account.DomainsCertificate.Domain.Main
+	key := s.key(domain)
+	s.setSaved(domain, data)
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", s.revision(domain))).
+		Then(clientv3.OpPut(key, string(data))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return backend.ErrConflict
+	}
+	s.setRevision(domain, resp.Header.Revision)
+	return nil
+}
+
+// LoadAccount loads the account from etcd.
+func (s *storage) LoadAccount(domain string) (*types.Account, error) {
+	return s.LoadAccountContext(context.Background(), domain)
+}
+
+// LoadAccountContext loads the account from etcd.
+func (s *storage) LoadAccountContext(ctx context.Context, domain string) (*types.Account, error) {
+	resp, err := s.client.Get(ctx, s.key(domain))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		s.setRevision(domain, 0)
+		return nil, nil
+	}
+	account := types.Account{
+		DomainsCertificate: &types.DomainCertificate{},
+	}
+	if err := json.Unmarshal(resp.Kvs[0].Value, &account); err != nil {
+		return nil, fmt.Errorf("Error loading account: %v", err)
+	}
+	s.setRevision(domain, resp.Kvs[0].ModRevision)
+	return &account, nil
+}
+
+// DeleteAccount deletes the account from etcd.
+func (s *storage) DeleteAccount(domain string) error {
+	if _, err := s.client.Delete(context.Background(), s.key(domain)); err != nil {
+		return err
+	}
+	s.setRevision(domain, 0)
+	return nil
+}
+
+// Lock acquires the lock of the domain, held with a lease which expires if the process dies.
+// The context returned is done if the lease is lost.
+func (s *storage) Lock(ctx context.Context, domain string) (context.Context, func() error, error) {
+	session, err := concurrency.NewSession(s.client, concurrency.WithTTL(s.lockTTL))
+	if err != nil {
+		return nil, nil, err
+	}
+	mutex := concurrency.NewMutex(session, s.prefix+"locks/"+domain)
+	if err := mutex.Lock(ctx); err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+	locked, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-session.Done():
+			cancel()
+		case <-locked.Done():
+		}
+	}()
+	return locked, func() error {
+		cancel()
+		defer session.Close()
+		return mutex.Unlock(context.Background())
+	}, nil
+}
+
+// Watch notifies the changes of the account made by other replicas.
+func (s *storage) Watch(ctx context.Context, domain string) (<-chan struct{}, error) {
+	changes := make(chan struct{}, 1)
+	events := s.client.Watch(ctx, s.key(domain))
+	go func() {
+		defer close(changes)
+		for resp := range events {
+			for _, event := range resp.Events {
+				if event.Type != mvccpb.PUT || s.ownChange(domain, event.Kv) {
+					continue
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+func newBackend(config backend.Config) (backend.Interface, error) {
+	endpoints := config.Get("endpoints", endpointsEnv)
+	if endpoints == "" {
+		endpoints = defaultEndpoints
+	}
+	prefix := config.Get("prefix", prefixEnv)
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	dialTimeout := defaultDialTimeout
+	if timeout := config.Get("dial_timeout", dialTimeoutEnv); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid etcd dial timeout %q: %v", timeout, err)
+		}
+		dialTimeout = d
+	}
+	lockTTL := defaultLockTTL
+	if ttl := config.Get("lock_ttl", lockTTLEnv); ttl != "" {
+		n, err := strconv.Atoi(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid etcd lock TTL %q: %v", ttl, err)
+		}
+		lockTTL = n
+	}
+	tlsConfig, err := config.TLSConfig(envPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(endpoints, ","),
+		DialTimeout: dialTimeout,
+		TLS:         tlsConfig,
+		Username:    config.Get("username", usernameEnv),
+		Password:    config.Get("password", passwordEnv),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &storage{
+		client:    client,
+		prefix:    prefix,
+		lockTTL:   lockTTL,
+		revisions: make(map[string]int64),
+		saved:     make(map[string][]byte),
+	}, nil
+}
+
+func init() {
+	backend.RegisterConfigBackend(backendName, func(config backend.Config) (backend.Interface, error) {
+		return newBackend(config)
+	})
+}
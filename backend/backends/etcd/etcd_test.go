@@ -0,0 +1,149 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/server/v3/embed"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/backend/backendtest"
+)
+
+// freeURL returns a localhost URL with a free port.
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// startEtcd starts an embedded single node etcd server and returns its client endpoint.
+func startEtcd(t *testing.T) string {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, peerURL.String())
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Embedded etcd server not ready")
+	}
+	return clientURL.Host
+}
+
+func newTestStorage(t *testing.T, endpoint string) *storage {
+	b, err := newBackend(backend.Config{"endpoints": endpoint, "prefix": "/test", "lock_ttl": "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := b.(*storage)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBackend(t *testing.T) {
+	endpoint := startEtcd(t)
+	backendtest.TestBackend(t, func(t *testing.T) backend.Interface {
+		return newTestStorage(t, endpoint)
+	})
+}
+
+func TestLock(t *testing.T) {
+	endpoint := startEtcd(t)
+	first, second := newTestStorage(t, endpoint), newTestStorage(t, endpoint)
+	_, unlock, err := first.Lock(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, _, err := second.Lock(ctx, "www.example.com"); err == nil {
+		t.Fatal("Expected the lock to be held by the first replica")
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, unlock, err = second.Lock(ctx, "www.example.com")
+	if err != nil {
+		t.Fatalf("Expected the lock to be acquired once released, got %v", err)
+	}
+	unlock()
+}
+
+func TestWatch(t *testing.T) {
+	endpoint := startEtcd(t)
+	first, second := newTestStorage(t, endpoint), newTestStorage(t, endpoint)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := second.Watch(ctx, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the changes saved by the replica itself are not notified.
+	if err := second.SaveAccount(backendtest.Account("www.example.com", "second@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+		t.Fatal("Expected no notification of the own save")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if _, err := first.LoadAccount("www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.SaveAccount(backendtest.Account("www.example.com", "first@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a notification of the save of the other replica")
+	}
+}
+
+func TestClose(t *testing.T) {
+	b, err := newBackend(backend.Config{"endpoints": startEtcd(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.LoadAccount("www.example.com"); err == nil {
+		t.Error("Expected an error loading an account with the closed client")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := map[string]backend.Config{
+		"invalid dial timeout": {"dial_timeout": "soon"},
+		"invalid lock ttl":     {"lock_ttl": "long"},
+		"missing ca file":      {"ca_file": "/does/not/exist"},
+	}
+	for name, config := range tests {
+		if _, err := newBackend(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/jtblin/go-acme/backend"
@@ -13,8 +15,10 @@ import (
 )
 
 const (
-	backendName   = "fs"
-	storageDirEnv = "STORAGE_DIR"
+	backendName      = "fs"
+	defaultListLimit = 1000
+	fileExtension    = ".json"
+	storageDirEnv    = "STORAGE_DIR"
 )
 
 type storage struct {
@@ -28,7 +32,7 @@ func (s *storage) Name() string {
 }
 
 func (s *storage) key(domain string) string {
-	return path.Join(s.StorageDir, domain) + ".json"
+	return path.Join(s.StorageDir, domain) + fileExtension
 }
 
 // SaveAccount saves the account to the filesystem.
@@ -70,8 +74,59 @@ func (s *storage) LoadAccount(domain string) (*types.Account, error) {
 	return &account, nil
 }
 
-func newBackend() (backend.Interface, error) {
-	storageDir := os.Getenv(storageDirEnv)
+// DeleteAccount deletes the account from the filesystem.
+func (s *storage) DeleteAccount(domain string) error {
+	s.storageLock.Lock()
+	defer s.storageLock.Unlock()
+	if err := os.Remove(s.key(domain)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List lists the accounts in the filesystem in domain order, the marker is the last domain returned.
+func (s *storage) List(marker string, limit int) ([]backend.Info, string, error) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	s.storageLock.RLock()
+	files, err := ioutil.ReadDir(s.StorageDir)
+	s.storageLock.RUnlock()
+	if err != nil {
+		return nil, "", err
+	}
+
+	infos := []backend.Info{}
+	for _, file := range files {
+		domain := strings.TrimSuffix(file.Name(), fileExtension)
+		if file.IsDir() || domain == file.Name() || domain <= marker {
+			continue
+		}
+		infos = append(infos, backend.Info{Domain: domain, LastModified: file.ModTime(), Size: file.Size()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Domain < infos[j].Domain })
+	if len(infos) <= limit {
+		return infos, "", nil
+	}
+	return infos[:limit], infos[limit-1].Domain, nil
+}
+
+// Stat returns the metadata of the account in the filesystem.
+func (s *storage) Stat(domain string) (*backend.Info, error) {
+	s.storageLock.RLock()
+	defer s.storageLock.RUnlock()
+	fileInfo, err := os.Stat(s.key(domain))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &backend.Info{Domain: domain, LastModified: fileInfo.ModTime(), Size: fileInfo.Size()}, nil
+}
+
+func newBackend(config backend.Config) (backend.Interface, error) {
+	storageDir := config.Get("storage_dir", storageDirEnv)
 	if storageDir != "" {
 		return &storage{StorageDir: storageDir}, nil
 
@@ -85,7 +140,7 @@ func newBackend() (backend.Interface, error) {
 }
 
 func init() {
-	backend.RegisterBackend(backendName, func() (backend.Interface, error) {
-		return newBackend()
+	backend.RegisterConfigBackend(backendName, func(config backend.Config) (backend.Interface, error) {
+		return newBackend(config)
 	})
 }
@@ -0,0 +1,80 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+func newTestStorage(t *testing.T, domains ...string) *storage {
+	s := &storage{StorageDir: t.TempDir()}
+	for _, domain := range domains {
+		account := &types.Account{
+			Email:              "user@example.com",
+			DomainsCertificate: &types.DomainCertificate{Domain: &types.Domain{Main: domain}},
+		}
+		if err := s.SaveAccount(account); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestDeleteAccount(t *testing.T) {
+	s := newTestStorage(t, "www.example.com")
+	if err := backend.DeleteAccount(s, "www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if account, err := s.LoadAccount("www.example.com"); err != nil || account != nil {
+		t.Errorf("Expected the account to be deleted, got %+v, %v", account, err)
+	}
+	if err := s.DeleteAccount("www.example.com"); err != nil {
+		t.Errorf("Expected no error deleting a missing account, got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	s := newTestStorage(t, "c.example.com", "a.example.com", "b.example.com")
+	// the other files and directories are skipped.
+	if err := ioutil.WriteFile(filepath.Join(s.StorageDir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(s.StorageDir, "dir.json"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var domains []string
+	infos, next, err := backend.List(s, "", 2)
+	if err != nil || next != "b.example.com" || len(infos) != 2 {
+		t.Fatalf("Expected a first page of 2 accounts, got %+v, %q, %v", infos, next, err)
+	}
+	for _, info := range infos {
+		domains = append(domains, info.Domain)
+	}
+	if infos, next, err = s.List(next, 2); err != nil || next != "" || len(infos) != 1 {
+		t.Fatalf("Expected a last page of 1 account, got %+v, %q, %v", infos, next, err)
+	}
+	domains = append(domains, infos[0].Domain)
+	if strings.Join(domains, ",") != "a.example.com,b.example.com,c.example.com" {
+		t.Errorf("Expected the accounts in domain order, got %v", domains)
+	}
+	if infos[0].Size == 0 || infos[0].LastModified.IsZero() {
+		t.Errorf("Expected the file metadata, got %+v", infos[0])
+	}
+}
+
+func TestStat(t *testing.T) {
+	s := newTestStorage(t, "www.example.com")
+	info, err := backend.Stat(s, "www.example.com")
+	if err != nil || info == nil || info.Domain != "www.example.com" || info.Size == 0 {
+		t.Errorf("Expected the account metadata, got %+v, %v", info, err)
+	}
+	if info, err := s.Stat("other.example.com"); err != nil || info != nil {
+		t.Errorf("Expected no metadata for a missing account, got %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,255 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+const (
+	backendName      = "kubernetes"
+	namespaceEnv     = "KUBERNETES_NAMESPACE"
+	kubeconfigEnv    = "KUBECONFIG"
+	secretPrefixEnv  = "KUBERNETES_SECRET_PREFIX"
+	namespaceFile    = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	defaultNamespace = "default"
+	accountKey       = "account.json"
+	accountSuffix    = "-acme-account"
+	tlsSuffix        = "-tls"
+	managedByLabel   = "app.kubernetes.io/managed-by"
+	managedBy        = "go-acme"
+	domainAnnotation = "go-acme/domain"
+)
+
+// storage stores the certificates in kubernetes.io/tls secrets, consumable by ingress controllers
+// and sidecars, and the account details in companion opaque secrets. It implements the
+// backend.ContextInterface and backend.Deleter interfaces.
+type storage struct {
+	client    kubernetes.Interface
+	namespace string
+	prefix    string
+	// resourceVersions holds the resource version of the secrets last loaded or saved by name,
+	// saves are optimistic updates against it.
+	resourceVersions     map[string]string
+	resourceVersionsLock sync.Mutex
+}
+
+// Name returns the display name of the backend.
+func (s *storage) Name() string {
+	return backendName
+}
+
+// secretNames returns the names of the TLS and account secrets of the domain.
+func (s *storage) secretNames(domain string) (string, string) {
+	name := s.prefix + strings.Replace(strings.ToLower(domain), "*", "wildcard", -1)
+	return name + tlsSuffix, name + accountSuffix
+}
+
+func (s *storage) resourceVersion(name string) string {
+	s.resourceVersionsLock.Lock()
+	defer s.resourceVersionsLock.Unlock()
+	return s.resourceVersions[name]
+}
+
+func (s *storage) setResourceVersion(name, resourceVersion string) {
+	s.resourceVersionsLock.Lock()
+	defer s.resourceVersionsLock.Unlock()
+	s.resourceVersions[name] = resourceVersion
+}
+
+// SaveAccount saves the account to kubernetes secrets.
+func (s *storage) SaveAccount(account *types.Account) error {
+	return s.SaveAccountContext(context.Background(), account)
+}
+
+// SaveAccountContext saves the account without the certificate to the account secret if it was not
+// modified since it was last loaded or saved, and returns backend.ErrConflict otherwise. The account
+// secret is saved first so that a replica with a stale account does not overwrite the certificate,
+// which is then saved to the TLS secret if the secret is missing or holds another certificate.
+func (s *storage) SaveAccountContext(ctx context.Context, account *types.Account) error {
+	domain := account.DomainsCertificate.Domain.Main
+	tlsName, accountName := s.secretNames(domain)
+
+	stored := *account
+	dc := *account.DomainsCertificate
+	var certificate map[string][]byte
+	if cert := dc.Certificate; cert != nil && len(cert.Cert) > 0 {
+		certificate = map[string][]byte{
+			corev1.TLSCertKey:       cert.Cert,
+			corev1.TLSPrivateKeyKey: cert.PrivateKey,
+		}
+		// the TLS secret holds the PEMs.
+		withoutPEMs := *cert
+		withoutPEMs.Cert, withoutPEMs.PrivateKey = nil, nil
+		dc.Certificate = &withoutPEMs
+	}
+	stored.DomainsCertificate = &dc
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	if err := s.saveSecret(ctx, domain, accountName, corev1.SecretTypeOpaque, map[string][]byte{accountKey: data}); err != nil {
+		return err
+	}
+	if certificate == nil {
+		return nil
+	}
+	tlsSecret, err := s.loadSecret(ctx, tlsName)
+	if err != nil {
+		return err
+	}
+	if tlsSecret != nil && bytes.Equal(tlsSecret.Data[corev1.TLSCertKey], certificate[corev1.TLSCertKey]) &&
+		bytes.Equal(tlsSecret.Data[corev1.TLSPrivateKeyKey], certificate[corev1.TLSPrivateKeyKey]) {
+		return nil
+	}
+	return s.saveSecret(ctx, domain, tlsName, corev1.SecretTypeTLS, certificate)
+}
+
+// saveSecret creates the secret, or updates it with the resource version it was last loaded or saved with.
+func (s *storage) saveSecret(ctx context.Context, domain, name string, secretType corev1.SecretType, data map[string][]byte) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       s.namespace,
+			ResourceVersion: s.resourceVersion(name),
+			Labels:          map[string]string{managedByLabel: managedBy},
+			Annotations:     map[string]string{domainAnnotation: domain},
+		},
+		Type: secretType,
+		Data: data,
+	}
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+	var err error
+	if secret.ResourceVersion == "" {
+		secret, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	} else {
+		secret, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+		return backend.ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("Error saving secret %s/%s: %v", s.namespace, name, err)
+	}
+	s.setResourceVersion(name, secret.ResourceVersion)
+	return nil
+}
+
+// LoadAccount loads the account from kubernetes secrets.
+func (s *storage) LoadAccount(domain string) (*types.Account, error) {
+	return s.LoadAccountContext(context.Background(), domain)
+}
+
+// LoadAccountContext loads the account from the account secret and the certificate from the TLS secret.
+func (s *storage) LoadAccountContext(ctx context.Context, domain string) (*types.Account, error) {
+	tlsName, accountName := s.secretNames(domain)
+	accountSecret, err := s.loadSecret(ctx, accountName)
+	if err != nil || accountSecret == nil {
+		return nil, err
+	}
+	tlsSecret, err := s.loadSecret(ctx, tlsName)
+	if err != nil {
+		return nil, err
+	}
+
+	account := types.Account{
+		DomainsCertificate: &types.DomainCertificate{},
+	}
+	if err := json.Unmarshal(accountSecret.Data[accountKey], &account); err != nil {
+		return nil, fmt.Errorf("Error loading account: %v", err)
+	}
+	if tlsSecret != nil {
+		if account.DomainsCertificate.Certificate == nil {
+			account.DomainsCertificate.Certificate = &types.Certificate{}
+		}
+		account.DomainsCertificate.Certificate.Cert = tlsSecret.Data[corev1.TLSCertKey]
+		account.DomainsCertificate.Certificate.PrivateKey = tlsSecret.Data[corev1.TLSPrivateKeyKey]
+	}
+	return &account, nil
+}
+
+// loadSecret returns the secret, or nil if it does not exist, and records its resource version.
+func (s *storage) loadSecret(ctx context.Context, name string) (*corev1.Secret, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		s.setResourceVersion(name, "")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error loading secret %s/%s: %v", s.namespace, name, err)
+	}
+	s.setResourceVersion(name, secret.ResourceVersion)
+	return secret, nil
+}
+
+// DeleteAccount deletes the TLS and account secrets.
+func (s *storage) DeleteAccount(domain string) error {
+	tlsName, accountName := s.secretNames(domain)
+	for _, name := range []string{tlsName, accountName} {
+		err := s.client.CoreV1().Secrets(s.namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		s.setResourceVersion(name, "")
+	}
+	return nil
+}
+
+// restConfig returns the in-cluster configuration, or the kubeconfig configuration if set.
+func restConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return rest.InClusterConfig()
+}
+
+func newBackend(config backend.Config) (backend.Interface, error) {
+	restConfig, err := restConfig(config.Get("kubeconfig", kubeconfigEnv))
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	namespace := config.Get("namespace", namespaceEnv)
+	if namespace == "" {
+		// the namespace of the pod when running in cluster.
+		if data, err := ioutil.ReadFile(namespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return newStorage(client, namespace, config.Get("secret_prefix", secretPrefixEnv)), nil
+}
+
+func newStorage(client kubernetes.Interface, namespace, prefix string) *storage {
+	return &storage{
+		client:           client,
+		namespace:        namespace,
+		prefix:           prefix,
+		resourceVersions: make(map[string]string),
+	}
+}
+
+func init() {
+	backend.RegisterConfigBackend(backendName, func(config backend.Config) (backend.Interface, error) {
+		return newBackend(config)
+	})
+}
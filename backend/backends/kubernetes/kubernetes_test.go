@@ -0,0 +1,233 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/backend/backendtest"
+	"github.com/jtblin/go-acme/types"
+)
+
+const testNamespace = "acme"
+
+var secretsResource = corev1.SchemeGroupVersion.WithResource("secrets")
+
+// newFakeClient returns a fake clientset setting the resource version of the secrets on writes
+// and rejecting the updates of stale secrets like the API server does.
+func newFakeClient() *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	var version int
+	client.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secret := action.(k8stesting.CreateAction).GetObject().(*corev1.Secret).DeepCopy()
+		version++
+		secret.ResourceVersion = strconv.Itoa(version)
+		if err := client.Tracker().Create(secretsResource, secret, secret.Namespace); err != nil {
+			return true, nil, err
+		}
+		return true, secret, nil
+	})
+	client.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secret := action.(k8stesting.UpdateAction).GetObject().(*corev1.Secret).DeepCopy()
+		existing, err := client.Tracker().Get(secretsResource, secret.Namespace, secret.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		if existing.(*corev1.Secret).ResourceVersion != secret.ResourceVersion {
+			return true, nil, apierrors.NewConflict(secretsResource.GroupResource(), secret.Name, errors.New("the object has been modified"))
+		}
+		version++
+		secret.ResourceVersion = strconv.Itoa(version)
+		if err := client.Tracker().Update(secretsResource, secret, secret.Namespace); err != nil {
+			return true, nil, err
+		}
+		return true, secret, nil
+	})
+	return client
+}
+
+// writes returns the number of creates and updates of the secret.
+func writes(client *fake.Clientset, name string) int {
+	var count int
+	for _, action := range client.Actions() {
+		switch action := action.(type) {
+		case k8stesting.CreateAction:
+			if action.GetObject().(*corev1.Secret).Name == name {
+				count++
+			}
+		case k8stesting.UpdateAction:
+			if action.GetObject().(*corev1.Secret).Name == name {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func getSecret(t *testing.T, client *fake.Clientset, name string) *corev1.Secret {
+	secret, err := client.Tracker().Get(secretsResource, testNamespace, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret.(*corev1.Secret)
+}
+
+func testAccount(email, cert string) *types.Account {
+	return &types.Account{
+		Email: email,
+		DomainsCertificate: &types.DomainCertificate{
+			Domain:      &types.Domain{Main: "www.example.com"},
+			Certificate: &types.Certificate{Domain: "www.example.com", Cert: []byte(cert), PrivateKey: []byte("key-" + cert)},
+		},
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	client := newFakeClient()
+	s := newStorage(client, testNamespace, "")
+	if account, err := s.LoadAccount("www.example.com"); err != nil || account != nil {
+		t.Fatalf("Expected no account, got %+v, %v", account, err)
+	}
+	if err := s.SaveAccount(testAccount("user@example.com", "cert")); err != nil {
+		t.Fatalf("Expected save to succeed, got %v", err)
+	}
+
+	tlsSecret := getSecret(t, client, "www.example.com-tls")
+	if tlsSecret.Type != corev1.SecretTypeTLS || string(tlsSecret.Data[corev1.TLSCertKey]) != "cert" ||
+		string(tlsSecret.Data[corev1.TLSPrivateKeyKey]) != "key-cert" {
+		t.Errorf("Unexpected TLS secret %+v", tlsSecret)
+	}
+	if tlsSecret.Labels[managedByLabel] != managedBy || tlsSecret.Annotations[domainAnnotation] != "www.example.com" {
+		t.Errorf("Unexpected TLS secret metadata %+v", tlsSecret.ObjectMeta)
+	}
+	accountSecret := getSecret(t, client, "www.example.com-acme-account")
+	stored := types.Account{DomainsCertificate: &types.DomainCertificate{}}
+	if err := json.Unmarshal(accountSecret.Data[accountKey], &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.DomainsCertificate.Certificate == nil || stored.DomainsCertificate.Certificate.Cert != nil {
+		t.Errorf("Expected the account secret without the PEMs, got %+v", stored.DomainsCertificate.Certificate)
+	}
+
+	account, err := newStorage(client, testNamespace, "").LoadAccount("www.example.com")
+	if err != nil || account == nil || account.Email != "user@example.com" {
+		t.Fatalf("Expected the account to be loaded, got %+v, %v", account, err)
+	}
+	if cert := account.DomainsCertificate.Certificate; string(cert.Cert) != "cert" || string(cert.PrivateKey) != "key-cert" {
+		t.Errorf("Expected the certificate to be loaded from the TLS secret, got %+v", cert)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	client := newFakeClient()
+	backendtest.TestBackend(t, func(t *testing.T) backend.Interface {
+		return newStorage(client, testNamespace, "")
+	})
+}
+
+func TestSaveConflict(t *testing.T) {
+	client := newFakeClient()
+	first, second := newStorage(client, testNamespace, ""), newStorage(client, testNamespace, "")
+	if err := first.SaveAccount(testAccount("first@example.com", "first")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.LoadAccount("www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.SaveAccount(testAccount("second@example.com", "second")); err != nil {
+		t.Fatal(err)
+	}
+	// the conflict on the account secret is detected before the certificate is written.
+	if err := first.SaveAccount(testAccount("first@example.com", "renewed")); err != backend.ErrConflict {
+		t.Errorf("Expected a conflict updating a stale account secret, got %v", err)
+	}
+	if tlsSecret := getSecret(t, client, "www.example.com-tls"); string(tlsSecret.Data[corev1.TLSCertKey]) != "second" {
+		t.Errorf("Expected the certificate of the second replica, got %s", tlsSecret.Data[corev1.TLSCertKey])
+	}
+}
+
+func TestSaveUnchangedCertificate(t *testing.T) {
+	client := newFakeClient()
+	s := newStorage(client, testNamespace, "")
+	for i := 0; i < 3; i++ {
+		if err := s.SaveAccount(testAccount("user@example.com", "cert")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tlsWrites, accountWrites := writes(client, "www.example.com-tls"), writes(client, "www.example.com-acme-account"); tlsWrites != 1 || accountWrites != 3 {
+		t.Errorf("Expected the TLS secret to be written once and the account secret 3 times, got %d and %d", tlsWrites, accountWrites)
+	}
+
+	// the certificate loaded by another replica is not written again either.
+	other := newStorage(client, testNamespace, "")
+	if _, err := other.LoadAccount("www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SaveAccount(testAccount("user@example.com", "cert")); err != nil {
+		t.Fatal(err)
+	}
+	if tlsWrites := writes(client, "www.example.com-tls"); tlsWrites != 1 {
+		t.Errorf("Expected the loaded TLS secret not to be written, got %d writes", tlsWrites)
+	}
+
+	if err := other.SaveAccount(testAccount("user@example.com", "renewed")); err != nil {
+		t.Fatal(err)
+	}
+	if tlsWrites := writes(client, "www.example.com-tls"); tlsWrites != 2 {
+		t.Errorf("Expected the renewed certificate to be written, got %d writes", tlsWrites)
+	}
+	if tlsSecret := getSecret(t, client, "www.example.com-tls"); string(tlsSecret.Data[corev1.TLSCertKey]) != "renewed" {
+		t.Errorf("Expected the renewed certificate, got %s", tlsSecret.Data[corev1.TLSCertKey])
+	}
+}
+
+func TestSaveOrder(t *testing.T) {
+	client := newFakeClient()
+	s := newStorage(client, testNamespace, "")
+	if err := s.SaveAccount(testAccount("user@example.com", "cert")); err != nil {
+		t.Fatal(err)
+	}
+	var written []string
+	for _, action := range client.Actions() {
+		if action, ok := action.(k8stesting.CreateAction); ok {
+			written = append(written, action.GetObject().(*corev1.Secret).Name)
+		}
+	}
+	if len(written) != 2 || written[0] != "www.example.com-acme-account" || written[1] != "www.example.com-tls" {
+		t.Errorf("Expected the account secret written before the TLS secret, got %v", written)
+	}
+}
+
+func TestSaveDeletedCertificate(t *testing.T) {
+	client := newFakeClient()
+	s := newStorage(client, testNamespace, "")
+	if err := s.SaveAccount(testAccount("user@example.com", "cert")); err != nil {
+		t.Fatal(err)
+	}
+	// the TLS secret is deleted e.g. by an operator, the unchanged certificate is written again.
+	if err := client.Tracker().Delete(secretsResource, testNamespace, "www.example.com-tls"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveAccount(testAccount("user@example.com", "cert")); err != nil {
+		t.Fatal(err)
+	}
+	if tlsSecret := getSecret(t, client, "www.example.com-tls"); string(tlsSecret.Data[corev1.TLSCertKey]) != "cert" {
+		t.Errorf("Expected the TLS secret written again, got %+v", tlsSecret)
+	}
+}
+
+func TestSecretNames(t *testing.T) {
+	s := newStorage(newFakeClient(), testNamespace, "prod-")
+	tlsName, accountName := s.secretNames("*.Example.com")
+	if tlsName != "prod-wildcard.example.com-tls" || accountName != "prod-wildcard.example.com-acme-account" {
+		t.Errorf("Unexpected secret names %s and %s", tlsName, accountName)
+	}
+}
@@ -26,6 +26,21 @@ func (null *null) LoadAccount(domain string) (*types.Account, error) {
 	return &types.Account{}, nil
 }
 
+// DeleteAccount deletes the account from null.
+func (null *null) DeleteAccount(domain string) error {
+	return nil
+}
+
+// List lists the accounts in null.
+func (null *null) List(marker string, limit int) ([]backend.Info, string, error) {
+	return nil, "", nil
+}
+
+// Stat returns the metadata of the account in null.
+func (null *null) Stat(domain string) (*backend.Info, error) {
+	return nil, nil
+}
+
 func newBackend() (backend.Interface, error) {
 	return &null{}, nil
 }
@@ -0,0 +1,23 @@
+package null
+
+import (
+	"testing"
+
+	"github.com/jtblin/go-acme/backend"
+)
+
+func TestOptionalInterfaces(t *testing.T) {
+	b, err := newBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.DeleteAccount(b, "www.example.com"); err != nil {
+		t.Errorf("Expected delete to succeed, got %v", err)
+	}
+	if infos, next, err := backend.List(b, "", 0); err != nil || len(infos) != 0 || next != "" {
+		t.Errorf("Expected no account listed, got %+v, %q, %v", infos, next, err)
+	}
+	if info, err := backend.Stat(b, "www.example.com"); err != nil || info != nil {
+		t.Errorf("Expected no metadata, got %+v, %v", info, err)
+	}
+}
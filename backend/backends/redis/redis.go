@@ -0,0 +1,203 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+const (
+	backendName   = "redis"
+	envPrefix     = "REDIS_"
+	addrsEnv      = "REDIS_ADDRS"
+	masterNameEnv = "REDIS_MASTER_NAME"
+	usernameEnv   = "REDIS_USERNAME"
+	passwordEnv   = "REDIS_PASSWORD"
+	dbEnv         = "REDIS_DB"
+	prefixEnv     = "REDIS_PREFIX"
+	tlsEnv        = "REDIS_TLS"
+	defaultAddrs  = "127.0.0.1:6379"
+	defaultPrefix = "go-acme:"
+	accountField  = "account"
+	versionField  = "version"
+)
+
+// storage stores the accounts in redis hashes holding the account and its version,
+// and implements the backend.ContextInterface, backend.Deleter and io.Closer interfaces.
+type storage struct {
+	client redis.UniversalClient
+	prefix string
+	// versions holds the version of the accounts last loaded or saved by domain,
+	// saves are compared and swapped against it.
+	versions     map[string]int64
+	versionsLock sync.Mutex
+}
+
+// Name returns the display name of the backend.
+func (s *storage) Name() string {
+	return backendName
+}
+
+// Close closes the redis client.
+func (s *storage) Close() error {
+	return s.client.Close()
+}
+
+func (s *storage) key(domain string) string {
+	return s.prefix + domain
+}
+
+func (s *storage) version(domain string) int64 {
+	s.versionsLock.Lock()
+	defer s.versionsLock.Unlock()
+	return s.versions[domain]
+}
+
+func (s *storage) setVersion(domain string, version int64) {
+	s.versionsLock.Lock()
+	defer s.versionsLock.Unlock()
+	s.versions[domain] = version
+}
+
+// SaveAccount saves the account to redis.
+func (s *storage) SaveAccount(account *types.Account) error {
+	return s.SaveAccountContext(context.Background(), account)
+}
+
+// SaveAccountContext saves the account to redis in a WATCH/MULTI transaction if it was not
+// modified since it was last loaded or saved, and returns backend.ErrConflict otherwise.
+func (s *storage) SaveAccountContext(ctx context.Context, account *types.Account) error {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	domain := account.DomainsCertificate.Domain.Main
+	key := s.key(domain)
+	var version int64
+	err = s.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.HGet(ctx, key, versionField).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if current != s.version(domain) {
+			return backend.ErrConflict
+		}
+		version = current + 1
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, accountField, data, versionField, version)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return backend.ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	s.setVersion(domain, version)
+	return nil
+}
+
+// LoadAccount loads the account from redis.
+func (s *storage) LoadAccount(domain string) (*types.Account, error) {
+	return s.LoadAccountContext(context.Background(), domain)
+}
+
+// LoadAccountContext loads the account from redis.
+func (s *storage) LoadAccountContext(ctx context.Context, domain string) (*types.Account, error) {
+	values, err := s.client.HMGet(ctx, s.key(domain), accountField, versionField).Result()
+	if err != nil {
+		return nil, err
+	}
+	data, ok := values[0].(string)
+	if !ok {
+		s.setVersion(domain, 0)
+		return nil, nil
+	}
+	var version int64
+	if v, ok := values[1].(string); ok {
+		if version, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("Invalid account version %q: %v", v, err)
+		}
+	}
+	account := types.Account{
+		DomainsCertificate: &types.DomainCertificate{},
+	}
+	if err := json.Unmarshal([]byte(data), &account); err != nil {
+		return nil, fmt.Errorf("Error loading account: %v", err)
+	}
+	s.setVersion(domain, version)
+	return &account, nil
+}
+
+// DeleteAccount deletes the account from redis.
+func (s *storage) DeleteAccount(domain string) error {
+	if err := s.client.Del(context.Background(), s.key(domain)).Err(); err != nil {
+		return err
+	}
+	s.setVersion(domain, 0)
+	return nil
+}
+
+func newBackend(config backend.Config) (backend.Interface, error) {
+	addrs := config.Get("addrs", addrsEnv)
+	if addrs == "" {
+		addrs = defaultAddrs
+	}
+	prefix := config.Get("prefix", prefixEnv)
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	var db int
+	if value := config.Get("db", dbEnv); value != "" {
+		var err error
+		if db, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("Invalid redis db %q: %v", value, err)
+		}
+	}
+	tlsConfig, err := config.TLSConfig(envPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if value := config.Get("tls", tlsEnv); value != "" && tlsConfig == nil {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid redis tls %q: %v", value, err)
+		}
+		if enabled {
+			tlsConfig = &tls.Config{}
+		}
+	}
+
+	// a master name selects sentinel addressing, multiple addresses cluster addressing.
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:      strings.Split(addrs, ","),
+		DB:         db,
+		Username:   config.Get("username", usernameEnv),
+		Password:   config.Get("password", passwordEnv),
+		MasterName: config.Get("master_name", masterNameEnv),
+		TLSConfig:  tlsConfig,
+	})
+	return &storage{
+		client:   client,
+		prefix:   prefix,
+		versions: make(map[string]int64),
+	}, nil
+}
+
+func init() {
+	backend.RegisterConfigBackend(backendName, func(config backend.Config) (backend.Interface, error) {
+		return newBackend(config)
+	})
+}
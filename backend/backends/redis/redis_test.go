@@ -0,0 +1,168 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/backend/backendtest"
+)
+
+func newTestStorage(t *testing.T, config backend.Config) *storage {
+	b, err := newBackend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := b.(*storage)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// testBackend runs the conformance checks of the backends with the storages created with config.
+func testBackend(t *testing.T, config backend.Config) {
+	backendtest.TestBackend(t, func(t *testing.T) backend.Interface {
+		return newTestStorage(t, config)
+	})
+}
+
+func TestBackend(t *testing.T) {
+	m := miniredis.RunT(t)
+	config := backend.Config{"addrs": m.Addr(), "prefix": "test:"}
+	s := newTestStorage(t, config)
+	if _, ok := s.client.(*redis.Client); !ok {
+		t.Errorf("Expected a single address to select a simple client, got %T", s.client)
+	}
+	testBackend(t, config)
+	// the conflicting save was not written.
+	if version := m.HGet("test:conflict.example.com", versionField); version != "3" {
+		t.Errorf("Expected version 3, got %q", version)
+	}
+}
+
+// modifyingHook modifies the watched key once, after the version was read in the transaction.
+type modifyingHook struct {
+	m    *miniredis.Miniredis
+	once sync.Once
+}
+
+func (h *modifyingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *modifyingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if cmd.Name() == "hget" {
+			h.once.Do(func() { h.m.HSet(defaultPrefix+"www.example.com", versionField, "7") })
+		}
+		return err
+	}
+}
+
+func (h *modifyingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestSaveWatchConflict(t *testing.T) {
+	m := miniredis.RunT(t)
+	s := newTestStorage(t, backend.Config{"addrs": m.Addr()})
+	s.client.AddHook(&modifyingHook{m: m})
+	if err := s.SaveAccount(backendtest.Account("www.example.com", "user@example.com")); err != backend.ErrConflict {
+		t.Fatalf("Expected a conflict when the key is modified during the transaction, got %v", err)
+	}
+	if account := m.HGet(defaultPrefix+"www.example.com", accountField); account != "" {
+		t.Errorf("Expected the transaction to be discarded, got %s", account)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	m := miniredis.RunT(t)
+	config := backend.Config{"addrs": m.Addr() + "," + m.Addr()}
+	s := newTestStorage(t, config)
+	if _, ok := s.client.(*redis.ClusterClient); !ok {
+		t.Fatalf("Expected multiple addresses to select a cluster client, got %T", s.client)
+	}
+	testBackend(t, config)
+}
+
+// fakeSentinel is a sentinel returning the address of a miniredis master and counting the lookups.
+type fakeSentinel struct {
+	*server.Server
+	mu      sync.Mutex
+	lookups int
+}
+
+func startSentinel(t *testing.T, masterName string, master *miniredis.Miniredis) *fakeSentinel {
+	srv, err := server.NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	s := &fakeSentinel{Server: srv}
+	srv.Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		switch {
+		case len(args) == 2 && strings.EqualFold(args[0], "get-master-addr-by-name") && args[1] == masterName:
+			s.mu.Lock()
+			s.lookups++
+			s.mu.Unlock()
+			c.WriteStrings([]string{master.Host(), master.Port()})
+		case len(args) == 2 && args[1] == masterName:
+			c.WriteLen(0)
+		default:
+			c.WriteNull()
+		}
+	})
+	srv.Register("SUBSCRIBE", func(c *server.Peer, cmd string, args []string) {
+		for i, channel := range args {
+			c.WriteLen(3)
+			c.WriteBulk("subscribe")
+			c.WriteBulk(channel)
+			c.WriteInt(i + 1)
+		}
+	})
+	return s
+}
+
+func TestSentinel(t *testing.T) {
+	m := miniredis.RunT(t)
+	sentinel := startSentinel(t, "mymaster", m)
+	testBackend(t, backend.Config{"addrs": sentinel.Addr().String(), "master_name": "mymaster"})
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+	if sentinel.lookups == 0 {
+		t.Error("Expected the master address to be looked up from the sentinel")
+	}
+}
+
+func TestClose(t *testing.T) {
+	m := miniredis.RunT(t)
+	b, err := newBackend(backend.Config{"addrs": m.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.LoadAccount("www.example.com"); err != redis.ErrClosed {
+		t.Errorf("Expected the client to be closed, got %v", err)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := map[string]backend.Config{
+		"invalid db":      {"db": "first"},
+		"invalid tls":     {"tls": "maybe"},
+		"missing ca file": {"ca_file": "/does/not/exist"},
+	}
+	for name, config := range tests {
+		if _, err := newBackend(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
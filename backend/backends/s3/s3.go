@@ -18,12 +18,12 @@ package s3
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"os"
 	"strings"
 	"sync"
 
@@ -45,7 +45,9 @@ const (
 	awsEncryptKeyEnv = "AWS_ENCRYPTION_KEY"
 	awsEncryptAlgEnv = "AWS_ENCRYPTION_ALG"
 	awsErrorNotFound = "NoSuchKey"
+	awsHeadNotFound  = "NotFound"
 	awsRegionEnv     = "AWS_REGION"
+	defaultListLimit = 1000
 	storageFilename  = "cert.json"
 )
 
@@ -74,7 +76,7 @@ type EC2Metadata interface {
 }
 
 // S3 is an abstraction over S3, to allow mocking/other implementations.
-// Note that the ListX functions return a list, so callers don't need to deal with paging.
+// Implementations may also implement S3WithContext and S3ObjectAdmin.
 type S3 interface {
 	// Get an object from S3.
 	GetObject(request *s3.GetObjectInput) (*s3.GetObjectOutput, error)
@@ -82,6 +84,25 @@ type S3 interface {
 	PutObject(request *s3.PutObjectInput) (*s3.PutObjectOutput, error)
 }
 
+// S3WithContext is implemented by S3 implementations whose requests can be cancelled with a context.
+type S3WithContext interface {
+	// Get an object from S3 until the context is done.
+	GetObjectWithContext(ctx context.Context, request *s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	// Put an object in S3 until the context is done.
+	PutObjectWithContext(ctx context.Context, request *s3.PutObjectInput) (*s3.PutObjectOutput, error)
+}
+
+// S3ObjectAdmin is implemented by S3 implementations supporting the delete, list and stat operations.
+// Note that ListObjects returns a single page, the marker of the next one is returned by List.
+type S3ObjectAdmin interface {
+	// Delete an object from S3.
+	DeleteObject(request *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	// Get the metadata of an object from S3.
+	HeadObject(request *s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	// List a page of objects in S3.
+	ListObjects(request *s3.ListObjectsInput) (*s3.ListObjectsOutput, error)
+}
+
 // awsSdkS3 is an implementation of the S3 interface, backed by aws-sdk-go.
 type awsSdkS3 struct {
 	s3 *s3.S3
@@ -89,7 +110,12 @@ type awsSdkS3 struct {
 
 // GetObject gets an object from an s3 bucket.
 func (s *awsSdkS3) GetObject(request *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
-	response, err := s.s3.GetObject(request)
+	return s.GetObjectWithContext(context.Background(), request)
+}
+
+// GetObjectWithContext gets an object from an s3 bucket until the context is done.
+func (s *awsSdkS3) GetObjectWithContext(ctx context.Context, request *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	response, err := s.s3.GetObjectWithContext(ctx, request)
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok {
 			if awsErr.Code() == awsErrorNotFound {
@@ -107,6 +133,36 @@ func (s *awsSdkS3) PutObject(request *s3.PutObjectInput) (*s3.PutObjectOutput, e
 	return s.s3.PutObject(request)
 }
 
+// PutObjectWithContext puts an object in an s3 bucket until the context is done.
+func (s *awsSdkS3) PutObjectWithContext(ctx context.Context, request *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return s.s3.PutObjectWithContext(ctx, request)
+}
+
+// DeleteObject deletes an object from an s3 bucket.
+func (s *awsSdkS3) DeleteObject(request *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	return s.s3.DeleteObject(request)
+}
+
+// HeadObject gets the metadata of an object from an s3 bucket.
+func (s *awsSdkS3) HeadObject(request *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	response, err := s.s3.HeadObject(request)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok {
+			if awsErr.Code() == awsHeadNotFound || awsErr.Code() == awsErrorNotFound {
+				return nil, nil
+			}
+		}
+
+		return nil, err
+	}
+	return response, nil
+}
+
+// ListObjects lists a page of objects in an s3 bucket.
+func (s *awsSdkS3) ListObjects(request *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	return s.s3.ListObjects(request)
+}
+
 // Metadata is an implementation of EC2 Metadata.
 func (p *awsSDKProvider) Metadata() (EC2Metadata, error) {
 	client := ec2metadata.New(session.New(&aws.Config{}))
@@ -142,8 +198,27 @@ func key(domain string) string {
 	return strings.Join(keySlice, "/")
 }
 
+// domain returns the domain of the key, or an empty string if the key is not an account.
+func domain(key string) string {
+	parts := strings.Split(key, "/")
+	l := len(parts) - 1
+	if l < 1 || parts[l] != storageFilename {
+		return ""
+	}
+	domainSlice := make([]string, l)
+	for idx, part := range parts[:l] {
+		domainSlice[l-1-idx] = part
+	}
+	return strings.Join(domainSlice, ".")
+}
+
 // SaveAccount saves the account to s3.
 func (s *storage) SaveAccount(account *types.Account) error {
+	return s.SaveAccountContext(context.Background(), account)
+}
+
+// SaveAccountContext saves the account to s3 until the context is done.
+func (s *storage) SaveAccountContext(ctx context.Context, account *types.Account) error {
 	s.storageLock.Lock()
 	defer s.storageLock.Unlock()
 
@@ -162,12 +237,21 @@ func (s *storage) SaveAccount(account *types.Account) error {
 		req.SSECustomerKey = aws.String(s.encryptionKey)
 		req.SSECustomerKeyMD5 = aws.String(fmt.Sprintf("%x", md5.Sum([]byte(s.encryptionKey))))
 	}
-	_, err = s.s3.PutObject(req)
+	if s3, ok := s.s3.(S3WithContext); ok {
+		_, err = s3.PutObjectWithContext(ctx, req)
+	} else {
+		_, err = s.s3.PutObject(req)
+	}
 	return err
 }
 
 // LoadAccount loads the account from s3.
 func (s *storage) LoadAccount(domain string) (*types.Account, error) {
+	return s.LoadAccountContext(context.Background(), domain)
+}
+
+// LoadAccountContext loads the account from s3 until the context is done.
+func (s *storage) LoadAccountContext(ctx context.Context, domain string) (*types.Account, error) {
 	s.storageLock.RLock()
 	defer s.storageLock.RUnlock()
 
@@ -181,7 +265,13 @@ func (s *storage) LoadAccount(domain string) (*types.Account, error) {
 		req.SSECustomerKeyMD5 = aws.String(fmt.Sprintf("%x", md5.Sum([]byte(s.encryptionKey))))
 	}
 
-	resp, err := s.s3.GetObject(req)
+	var resp *s3.GetObjectOutput
+	var err error
+	if s3, ok := s.s3.(S3WithContext); ok {
+		resp, err = s3.GetObjectWithContext(ctx, req)
+	} else {
+		resp, err = s.s3.GetObject(req)
+	}
 	if err != nil || resp == nil {
 		return nil, err
 	}
@@ -201,12 +291,108 @@ func (s *storage) LoadAccount(domain string) (*types.Account, error) {
 	return &account, nil
 }
 
-func newBackend(awsServices Services) (backend.Interface, error) {
-	bucket := os.Getenv(awsBucketEnv)
+// DeleteAccount deletes the account from s3.
+func (s *storage) DeleteAccount(domain string) error {
+	admin, ok := s.s3.(S3ObjectAdmin)
+	if !ok {
+		return backend.ErrNotSupported
+	}
+	s.storageLock.Lock()
+	defer s.storageLock.Unlock()
+
+	_, err := admin.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key(domain)),
+	})
+	return err
+}
+
+// List lists the accounts in s3 in key order, the marker is the last domain returned.
+// The objects which are not accounts are skipped, fetching more pages if needed.
+func (s *storage) List(marker string, limit int) ([]backend.Info, string, error) {
+	admin, ok := s.s3.(S3ObjectAdmin)
+	if !ok {
+		return nil, "", backend.ErrNotSupported
+	}
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	s.storageLock.RLock()
+	defer s.storageLock.RUnlock()
+
+	req := &s3.ListObjectsInput{Bucket: aws.String(s.bucket)}
+	if marker != "" {
+		req.Marker = aws.String(key(marker))
+	}
+	infos := []backend.Info{}
+	for {
+		req.MaxKeys = aws.Int64(int64(limit - len(infos)))
+		resp, err := admin.ListObjects(req)
+		if err != nil {
+			return nil, "", err
+		}
+		var last string
+		for _, object := range resp.Contents {
+			last = aws.StringValue(object.Key)
+			if d := domain(last); d != "" {
+				infos = append(infos, backend.Info{
+					Domain:       d,
+					LastModified: aws.TimeValue(object.LastModified),
+					Size:         aws.Int64Value(object.Size),
+				})
+			}
+		}
+		if !aws.BoolValue(resp.IsTruncated) {
+			return infos, "", nil
+		}
+		if len(infos) >= limit {
+			return infos, infos[len(infos)-1].Domain, nil
+		}
+		if next := aws.StringValue(resp.NextMarker); next != "" {
+			last = next
+		}
+		if last == "" {
+			return nil, "", fmt.Errorf("Error listing accounts: truncated page without marker")
+		}
+		req.Marker = aws.String(last)
+	}
+}
+
+// Stat returns the metadata of the account in s3.
+func (s *storage) Stat(domain string) (*backend.Info, error) {
+	admin, ok := s.s3.(S3ObjectAdmin)
+	if !ok {
+		return nil, backend.ErrNotSupported
+	}
+	s.storageLock.RLock()
+	defer s.storageLock.RUnlock()
+
+	req := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key(domain)),
+	}
+	if s.encryptionAlgorithm != "" && s.encryptionKey != "" {
+		req.SSECustomerAlgorithm = aws.String(s.encryptionAlgorithm)
+		req.SSECustomerKey = aws.String(s.encryptionKey)
+		req.SSECustomerKeyMD5 = aws.String(fmt.Sprintf("%x", md5.Sum([]byte(s.encryptionKey))))
+	}
+	resp, err := admin.HeadObject(req)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	return &backend.Info{
+		Domain:       domain,
+		LastModified: aws.TimeValue(resp.LastModified),
+		Size:         aws.Int64Value(resp.ContentLength),
+	}, nil
+}
+
+func newBackend(awsServices Services, config backend.Config) (backend.Interface, error) {
+	bucket := config.Get("bucket", awsBucketEnv)
 	if bucket == "" {
 		return nil, errors.New("missing bucket name")
 	}
-	region := os.Getenv(awsRegionEnv)
+	region := config.Get("region", awsRegionEnv)
 	if region == "" {
 		metadata, err := awsServices.Metadata()
 		if err != nil {
@@ -229,8 +415,8 @@ func newBackend(awsServices Services) (backend.Interface, error) {
 	}
 	return &storage{
 		bucket:              bucket,
-		encryptionAlgorithm: os.Getenv(awsEncryptAlgEnv),
-		encryptionKey:       os.Getenv(awsEncryptKeyEnv),
+		encryptionAlgorithm: config.Get("encryption_alg", awsEncryptAlgEnv),
+		encryptionKey:       config.Get("encryption_key", awsEncryptKeyEnv),
 		s3:                  s3,
 	}, nil
 }
@@ -240,7 +426,7 @@ func newAWSSDKProvider(creds *credentials.Credentials) *awsSDKProvider {
 }
 
 func init() {
-	backend.RegisterBackend(backendName, func() (backend.Interface, error) {
+	backend.RegisterConfigBackend(backendName, func(config backend.Config) (backend.Interface, error) {
 		creds := credentials.NewChainCredentials(
 			[]credentials.Provider{
 				&credentials.EnvProvider{},
@@ -249,7 +435,10 @@ func init() {
 				},
 				&credentials.SharedCredentialsProvider{},
 			})
+		if id := config.Get("access_key_id", ""); id != "" {
+			creds = credentials.NewStaticCredentials(id, config.Get("secret_access_key", ""), config.Get("session_token", ""))
+		}
 		aws := newAWSSDKProvider(creds)
-		return newBackend(aws)
+		return newBackend(aws, config)
 	})
 }
@@ -0,0 +1,193 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+// basicS3 implements the S3 interface only, like the implementations written before
+// the optional interfaces were added.
+type basicS3 struct {
+	objects map[string][]byte
+}
+
+func (b *basicS3) GetObject(request *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	data, found := b.objects[aws.StringValue(request.Key)]
+	if !found {
+		return nil, nil
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (b *basicS3) PutObject(request *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	data, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	b.objects[aws.StringValue(request.Key)] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+// contextS3 also implements the S3WithContext and S3ObjectAdmin interfaces.
+type contextS3 struct {
+	basicS3
+	contexts int
+	lists    int
+}
+
+func (c *contextS3) GetObjectWithContext(ctx context.Context, request *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	c.contexts++
+	return c.GetObject(request)
+}
+
+func (c *contextS3) PutObjectWithContext(ctx context.Context, request *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	c.contexts++
+	return c.PutObject(request)
+}
+
+func (c *contextS3) DeleteObject(request *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	delete(c.objects, aws.StringValue(request.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (c *contextS3) HeadObject(request *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	data, found := c.objects[aws.StringValue(request.Key)]
+	if !found {
+		return nil, nil
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(data)))}, nil
+}
+
+// ListObjects lists the objects after the marker in key order, without next marker like S3
+// when no delimiter is set.
+func (c *contextS3) ListObjects(request *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	c.lists++
+	var keys []string
+	for key := range c.objects {
+		if key > aws.StringValue(request.Marker) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	output := &s3.ListObjectsOutput{IsTruncated: aws.Bool(false)}
+	if max := int(aws.Int64Value(request.MaxKeys)); max > 0 && len(keys) > max {
+		keys, output.IsTruncated = keys[:max], aws.Bool(true)
+	}
+	for _, key := range keys {
+		output.Contents = append(output.Contents, &s3.Object{Key: aws.String(key), Size: aws.Int64(int64(len(c.objects[key])))})
+	}
+	return output, nil
+}
+
+func testAccount() *types.Account {
+	return &types.Account{
+		Email:              "user@example.com",
+		DomainsCertificate: &types.DomainCertificate{Domain: &types.Domain{Main: "www.example.com"}},
+	}
+}
+
+func TestBasicS3(t *testing.T) {
+	s := &storage{bucket: "bucket", s3: &basicS3{objects: make(map[string][]byte)}}
+	if err := s.SaveAccountContext(context.Background(), testAccount()); err != nil {
+		t.Fatalf("Expected save to succeed, got %v", err)
+	}
+	account, err := s.LoadAccountContext(context.Background(), "www.example.com")
+	if err != nil || account == nil || account.Email != "user@example.com" {
+		t.Fatalf("Expected the account to be loaded, got %+v, %v", account, err)
+	}
+
+	if err := s.DeleteAccount("www.example.com"); err != backend.ErrNotSupported {
+		t.Errorf("Expected delete not supported, got %v", err)
+	}
+	if _, _, err := s.List("", 0); err != backend.ErrNotSupported {
+		t.Errorf("Expected list not supported, got %v", err)
+	}
+	if _, err := s.Stat("www.example.com"); err != backend.ErrNotSupported {
+		t.Errorf("Expected stat not supported, got %v", err)
+	}
+}
+
+func TestOptionalInterfaces(t *testing.T) {
+	c := &contextS3{basicS3: basicS3{objects: make(map[string][]byte)}}
+	s := &storage{bucket: "bucket", s3: c}
+	if err := s.SaveAccountContext(context.Background(), testAccount()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoadAccountContext(context.Background(), "www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if c.contexts != 2 {
+		t.Errorf("Expected the context operations to be used, got %d calls", c.contexts)
+	}
+
+	infos, next, err := s.List("", 0)
+	if err != nil || next != "" || len(infos) != 1 || infos[0].Domain != "www.example.com" {
+		t.Errorf("Expected the account to be listed, got %+v, %q, %v", infos, next, err)
+	}
+	if info, err := s.Stat("www.example.com"); err != nil || info == nil || info.Size == 0 {
+		t.Errorf("Expected the account metadata, got %+v, %v", info, err)
+	}
+	if err := s.DeleteAccount("www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Stat("www.example.com"); err != nil || info != nil {
+		t.Errorf("Expected the account to be deleted, got %+v, %v", info, err)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	c := &contextS3{basicS3: basicS3{objects: make(map[string][]byte)}}
+	s := &storage{bucket: "bucket", s3: c}
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com", "www.example.net"} {
+		account := testAccount()
+		account.DomainsCertificate.Domain.Main = domain
+		if err := s.SaveAccount(account); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// objects which are not accounts are skipped.
+	c.objects["com/example/b/notes.txt"] = []byte("notes")
+	c.objects["com/example/b/backup/account.json.bak"] = []byte("backup")
+
+	var domains []string
+	marker := ""
+	for pages := 0; ; pages++ {
+		if pages > 4 {
+			t.Fatalf("Expected the listing to end, got %v", domains)
+		}
+		infos, next, err := s.List(marker, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) > 2 {
+			t.Errorf("Expected at most 2 accounts per page, got %d", len(infos))
+		}
+		for _, info := range infos {
+			domains = append(domains, info.Domain)
+		}
+		if next == "" {
+			break
+		}
+		if next != infos[len(infos)-1].Domain {
+			t.Errorf("Expected the last domain as marker, got %q", next)
+		}
+		marker = next
+	}
+	expected := "a.example.com,b.example.com,c.example.com,www.example.net"
+	if strings.Join(domains, ",") != expected {
+		t.Errorf("Expected the accounts %s, got %v", expected, domains)
+	}
+	if c.lists < 3 {
+		t.Errorf("Expected more pages to be fetched to skip the other objects, got %d", c.lists)
+	}
+}
@@ -0,0 +1,433 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/jtblin/go-logger"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+const (
+	backendName                = "vault"
+	mountEnv                   = "VAULT_MOUNT"
+	pathEnv                    = "VAULT_PATH"
+	namespaceEnv               = "VAULT_NAMESPACE"
+	authMethodEnv              = "VAULT_AUTH_METHOD"
+	authMountEnv               = "VAULT_AUTH_MOUNT"
+	roleIDEnv                  = "VAULT_ROLE_ID"
+	secretIDEnv                = "VAULT_SECRET_ID"
+	roleEnv                    = "VAULT_ROLE"
+	serviceAccountTokenPathEnv = "VAULT_SERVICE_ACCOUNT_TOKEN_PATH"
+	defaultMount               = "secret"
+	defaultPath                = "go-acme/{{.Domain}}"
+	defaultServiceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	accountKey                 = "account"
+
+	authToken      = "token"
+	authAppRole    = "approle"
+	authKubernetes = "kubernetes"
+)
+
+// retryInterval is the time to wait before logging in or renewing the token again after an error.
+var retryInterval = 30 * time.Second
+
+// storage stores the accounts in the vault KV v2 secrets engine and implements the
+// backend.ContextInterface, backend.Deleter, backend.LoggerSetter and io.Closer interfaces.
+type storage struct {
+	client *api.Client
+	mount  string
+	path   *template.Template
+	// versions holds the version of the accounts last loaded or saved by domain,
+	// saves are checked and set against it.
+	versions     map[string]int64
+	versionsLock sync.Mutex
+	login        func() (*api.Secret, error)
+	logger       logger.Interface
+	loggerLock   sync.Mutex
+	// done is closed to stop the token renewal.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Name returns the display name of the backend.
+func (s *storage) Name() string {
+	return backendName
+}
+
+// SetLogger sets the logger of the token renewal errors.
+func (s *storage) SetLogger(logger logger.Interface) {
+	s.loggerLock.Lock()
+	defer s.loggerLock.Unlock()
+	s.logger = logger
+}
+
+func (s *storage) printf(format string, v ...interface{}) {
+	s.loggerLock.Lock()
+	defer s.loggerLock.Unlock()
+	s.logger.Printf(format, v...)
+}
+
+// Close stops the token renewal.
+func (s *storage) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// sleep waits for the duration and returns false if the backend was closed meanwhile.
+func (s *storage) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// secretPath returns the path of the account secret relative to the mount.
+func (s *storage) secretPath(domain string) (string, error) {
+	var path bytes.Buffer
+	if err := s.path.Execute(&path, struct{ Domain string }{domain}); err != nil {
+		return "", err
+	}
+	return strings.Trim(path.String(), "/"), nil
+}
+
+func (s *storage) version(domain string) int64 {
+	s.versionsLock.Lock()
+	defer s.versionsLock.Unlock()
+	return s.versions[domain]
+}
+
+func (s *storage) setVersion(domain string, version int64) {
+	s.versionsLock.Lock()
+	defer s.versionsLock.Unlock()
+	s.versions[domain] = version
+}
+
+// SaveAccount saves the account to vault.
+func (s *storage) SaveAccount(account *types.Account) error {
+	return s.SaveAccountContext(context.Background(), account)
+}
+
+// SaveAccountContext writes a new version of the account to vault if it was not modified since
+// it was last loaded or saved, and returns backend.ErrConflict otherwise.
+func (s *storage) SaveAccountContext(ctx context.Context, account *types.Account) error {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	domain := account.DomainsCertificate.Domain.Main
+	path, err := s.secretPath(domain)
+	if err != nil {
+		return err
+	}
+	secret, err := s.client.Logical().WriteWithContext(ctx, s.mount+"/data/"+path, map[string]interface{}{
+		"data":    map[string]interface{}{accountKey: string(data)},
+		"options": map[string]interface{}{"cas": s.version(domain)},
+	})
+	if err != nil {
+		var respErr *api.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusBadRequest &&
+			strings.Contains(strings.Join(respErr.Errors, " "), "check-and-set") {
+			return backend.ErrConflict
+		}
+		return err
+	}
+	if secret != nil {
+		if version, err := versionOf(secret.Data); err == nil {
+			s.setVersion(domain, version)
+		}
+	}
+	return nil
+}
+
+// LoadAccount loads the account from vault.
+func (s *storage) LoadAccount(domain string) (*types.Account, error) {
+	return s.LoadAccountContext(context.Background(), domain)
+}
+
+// LoadAccountContext loads the latest version of the account from vault.
+func (s *storage) LoadAccountContext(ctx context.Context, domain string) (*types.Account, error) {
+	path, err := s.secretPath(domain)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.client.Logical().ReadWithContext(ctx, s.mount+"/data/"+path)
+	if err != nil {
+		return nil, err
+	}
+	// deleted versions have no data.
+	if secret == nil || secret.Data["data"] == nil {
+		s.setVersion(domain, 0)
+		if secret != nil {
+			if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+				if version, err := versionOf(metadata); err == nil {
+					s.setVersion(domain, version)
+				}
+			}
+		}
+		return nil, nil
+	}
+	data, _ := secret.Data["data"].(map[string]interface{})
+	value, ok := data[accountKey].(string)
+	if !ok {
+		return nil, fmt.Errorf("Error loading account: no %q key in vault secret %s", accountKey, path)
+	}
+	metadata, _ := secret.Data["metadata"].(map[string]interface{})
+	version, err := versionOf(metadata)
+	if err != nil {
+		return nil, err
+	}
+	account := types.Account{
+		DomainsCertificate: &types.DomainCertificate{},
+	}
+	if err := json.Unmarshal([]byte(value), &account); err != nil {
+		return nil, fmt.Errorf("Error loading account: %v", err)
+	}
+	s.setVersion(domain, version)
+	return &account, nil
+}
+
+// DeleteAccount deletes all the versions of the account from vault.
+func (s *storage) DeleteAccount(domain string) error {
+	path, err := s.secretPath(domain)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.Logical().DeleteWithContext(context.Background(), s.mount+"/metadata/"+path); err != nil {
+		return err
+	}
+	s.setVersion(domain, 0)
+	return nil
+}
+
+// renewToken renews the token in the background until the backend is closed, logging in again
+// when it cannot be renewed anymore. It stops when vault denies the renewal of a static token
+// or the login e.g. as the token or the secret ID was revoked, as retrying would not succeed.
+func (s *storage) renewToken(secret *api.Secret) {
+	for {
+		if secret.Auth.Renewable {
+			if err := s.watchToken(secret); err != nil {
+				s.printf("Error renewing vault token: %v\n", err)
+				if s.login == nil && !permissionDenied(err) {
+					if !s.sleep(retryInterval) {
+						return
+					}
+					continue
+				}
+			}
+		} else if secret.Auth.LeaseDuration > 0 {
+			if !s.sleep(time.Duration(secret.Auth.LeaseDuration) * time.Second * 2 / 3) {
+				return
+			}
+		} else {
+			return
+		}
+		if s.login == nil {
+			// a static token cannot be replaced once it reached its max TTL.
+			return
+		}
+		var err error
+		for {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			if secret, err = s.login(); err == nil {
+				break
+			}
+			s.printf("%v\n", err)
+			if permissionDenied(err) {
+				s.printf("Stopping the renewal of the vault token\n")
+				return
+			}
+			if !s.sleep(retryInterval) {
+				return
+			}
+		}
+	}
+}
+
+// watchToken renews the token until it reaches its max TTL, and returns the error if
+// the token could not be renewed.
+func (s *storage) watchToken(secret *api.Secret) error {
+	watcher, err := s.client.NewLifetimeWatcher(&api.LifetimeWatcherInput{Secret: secret})
+	if err != nil {
+		return err
+	}
+	go watcher.Start()
+	defer watcher.Stop()
+	for {
+		select {
+		case err := <-watcher.DoneCh():
+			return err
+		case <-watcher.RenewCh():
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+// permissionDenied returns true if vault denied the request.
+func permissionDenied(err error) bool {
+	var respErr *api.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden
+}
+
+func versionOf(data map[string]interface{}) (int64, error) {
+	switch version := data["version"].(type) {
+	case json.Number:
+		return version.Int64()
+	case float64:
+		return int64(version), nil
+	default:
+		return 0, fmt.Errorf("Invalid vault secret version %v", data["version"])
+	}
+}
+
+// loginFunc returns the function logging in with the auth method, or nil for token auth.
+func loginFunc(client *api.Client, config backend.Config) (func() (*api.Secret, error), error) {
+	method := config.Get("auth_method", authMethodEnv)
+	mount := config.Get("auth_mount", authMountEnv)
+	if mount == "" {
+		mount = method
+	}
+	var data map[string]interface{}
+	switch method {
+	case "", authToken:
+		return nil, nil
+	case authAppRole:
+		data = map[string]interface{}{
+			"role_id":   config.Get("role_id", roleIDEnv),
+			"secret_id": config.Get("secret_id", secretIDEnv),
+		}
+	case authKubernetes:
+		tokenPath := config.Get("service_account_token_path", serviceAccountTokenPathEnv)
+		if tokenPath == "" {
+			tokenPath = defaultServiceAccountToken
+		}
+		data = map[string]interface{}{"role": config.Get("role", roleEnv)}
+		return func() (*api.Secret, error) {
+			jwt, err := ioutil.ReadFile(tokenPath)
+			if err != nil {
+				return nil, err
+			}
+			data["jwt"] = strings.TrimSpace(string(jwt))
+			return login(client, mount, data)
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported vault auth method %q", method)
+	}
+	return func() (*api.Secret, error) {
+		return login(client, mount, data)
+	}, nil
+}
+
+func login(client *api.Client, mount string, data map[string]interface{}) (*api.Secret, error) {
+	secret, err := client.Logical().Write("auth/"+mount+"/login", data)
+	if err != nil {
+		return nil, fmt.Errorf("Error logging in to vault with %s: %w", mount, err)
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("Error logging in to vault with %s: no token returned", mount)
+	}
+	client.SetToken(secret.Auth.ClientToken)
+	return secret, nil
+}
+
+func newBackend(config backend.Config) (backend.Interface, error) {
+	// the vault client reads VAULT_ADDR, VAULT_TOKEN, VAULT_CACERT etc.
+	vaultConfig := api.DefaultConfig()
+	if address := config.Get("address", ""); address != "" {
+		vaultConfig.Address = address
+	}
+	tlsConfig := &api.TLSConfig{
+		CACert:     config.Get("ca_file", ""),
+		ClientCert: config.Get("cert_file", ""),
+		ClientKey:  config.Get("key_file", ""),
+	}
+	if insecure := config.Get("insecure_skip_verify", ""); insecure != "" {
+		skip, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid vault insecure_skip_verify %q: %v", insecure, err)
+		}
+		tlsConfig.Insecure = skip
+	}
+	// api.TLSConfig is not comparable.
+	if tlsConfig.CACert != "" || tlsConfig.ClientCert != "" || tlsConfig.ClientKey != "" || tlsConfig.Insecure {
+		if err := vaultConfig.ConfigureTLS(tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	client, err := api.NewClient(vaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	if namespace := config.Get("namespace", namespaceEnv); namespace != "" {
+		client.SetNamespace(namespace)
+	}
+	if token := config.Get("token", ""); token != "" {
+		client.SetToken(token)
+	}
+
+	mount := strings.Trim(config.Get("mount", mountEnv), "/")
+	if mount == "" {
+		mount = defaultMount
+	}
+	pathTemplate := config.Get("path", pathEnv)
+	if pathTemplate == "" {
+		pathTemplate = defaultPath
+	}
+	path, err := template.New("path").Parse(pathTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid vault path template %q: %v", pathTemplate, err)
+	}
+
+	s := &storage{
+		client:   client,
+		mount:    mount,
+		path:     path,
+		versions: make(map[string]int64),
+		logger:   log.New(os.Stdout, "[go-acme] ", log.Ldate|log.Ltime|log.Lshortfile),
+		done:     make(chan struct{}),
+	}
+	if s.login, err = loginFunc(client, config); err != nil {
+		return nil, err
+	}
+	var secret *api.Secret
+	if s.login != nil {
+		if secret, err = s.login(); err != nil {
+			return nil, err
+		}
+	} else if secret, err = client.Auth().Token().RenewSelf(0); err != nil {
+		// e.g. root or non renewable tokens.
+		secret = nil
+	}
+	if secret != nil && secret.Auth != nil {
+		go s.renewToken(secret)
+	}
+	return s, nil
+}
+
+func init() {
+	backend.RegisterConfigBackend(backendName, func(config backend.Config) (backend.Interface, error) {
+		return newBackend(config)
+	})
+}
@@ -0,0 +1,358 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/backend/backendtest"
+)
+
+const rootToken = "root"
+
+// testVault is a fake vault HTTP API serving a KV v2 secrets engine mounted on secret,
+// the logins of the auth methods and the renewal of the tokens.
+type testVault struct {
+	*httptest.Server
+	mu       sync.Mutex
+	accounts map[string]string
+	versions map[string]int64
+	tokens   map[string]bool
+	logins   []map[string]interface{}
+	renewals int
+	// leaseDuration makes the tokens issued by the logins renewable when positive.
+	leaseDuration int
+	// revoked fails the renewals and issues non renewable tokens.
+	revoked bool
+	// deniedLogins fails the logins e.g. after the secret ID was revoked.
+	deniedLogins bool
+}
+
+func newTestVault(t *testing.T) *testVault {
+	v := &testVault{
+		accounts: make(map[string]string),
+		versions: make(map[string]int64),
+		tokens:   map[string]bool{rootToken: true},
+	}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serve))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeErrors(w http.ResponseWriter, status int, errors ...string) {
+	writeJSON(w, status, map[string]interface{}{"errors": errors})
+}
+
+func (v *testVault) serve(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var body map[string]interface{}
+	if r.Body != nil && (r.Method == http.MethodPut || r.Method == http.MethodPost) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErrors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	if strings.HasPrefix(path, "auth/") && strings.HasSuffix(path, "/login") {
+		body["mount"] = strings.TrimSuffix(strings.TrimPrefix(path, "auth/"), "/login")
+		v.logins = append(v.logins, body)
+		if v.deniedLogins {
+			writeErrors(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		token := fmt.Sprintf("%s-token-%d", body["mount"], len(v.logins))
+		v.tokens[token] = true
+		writeJSON(w, http.StatusOK, map[string]interface{}{"auth": v.auth(token)})
+		return
+	}
+	token := r.Header.Get("X-Vault-Token")
+	if !v.tokens[token] {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	switch {
+	case path == "auth/token/renew-self":
+		if v.revoked {
+			writeErrors(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		v.renewals++
+		writeJSON(w, http.StatusOK, map[string]interface{}{"auth": v.auth(token)})
+	case strings.HasPrefix(path, "secret/data/"):
+		key := strings.TrimPrefix(path, "secret/data/")
+		switch r.Method {
+		case http.MethodGet:
+			account, found := v.accounts[key]
+			if !found {
+				writeErrors(w, http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+				"data":     map[string]interface{}{accountKey: account},
+				"metadata": map[string]interface{}{"version": v.versions[key]},
+			}})
+		case http.MethodPut, http.MethodPost:
+			options, _ := body["options"].(map[string]interface{})
+			if cas, ok := options["cas"].(float64); ok && int64(cas) != v.versions[key] {
+				writeErrors(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+				return
+			}
+			data, _ := body["data"].(map[string]interface{})
+			v.accounts[key], _ = data[accountKey].(string)
+			v.versions[key]++
+			writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"version": v.versions[key]}})
+		}
+	case strings.HasPrefix(path, "secret/metadata/") && r.Method == http.MethodDelete:
+		key := strings.TrimPrefix(path, "secret/metadata/")
+		delete(v.accounts, key)
+		delete(v.versions, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeErrors(w, http.StatusNotFound)
+	}
+}
+
+func (v *testVault) auth(token string) map[string]interface{} {
+	if v.revoked {
+		return map[string]interface{}{"client_token": token}
+	}
+	return map[string]interface{}{
+		"client_token":   token,
+		"renewable":      v.leaseDuration > 0,
+		"lease_duration": v.leaseDuration,
+	}
+}
+
+func (v *testVault) loginCount() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.logins)
+}
+
+// waitFor waits for the condition to be true.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestStorage(t *testing.T, config backend.Config) *storage {
+	b, err := newBackend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close(b) })
+	return b.(*storage)
+}
+
+// testLog is a log output safe for concurrent use.
+type testLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *testLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *testLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+func TestBackend(t *testing.T) {
+	v := newTestVault(t)
+	backendtest.TestBackend(t, func(t *testing.T) backend.Interface {
+		return newTestStorage(t, backend.Config{"address": v.URL, "token": rootToken, "path": "acme/{{.Domain}}"})
+	})
+	// each save writes a new version of the secret.
+	if v.versions["acme/conflict.example.com"] != 3 {
+		t.Errorf("Expected 3 versions of the account secret, got %v", v.versions)
+	}
+}
+
+func TestSavePermissionDenied(t *testing.T) {
+	v := newTestVault(t)
+	s := newTestStorage(t, backend.Config{"address": v.URL, "token": "unknown"})
+	err := s.SaveAccount(backendtest.Account("www.example.com", "user@example.com"))
+	if err == nil || err == backend.ErrConflict {
+		t.Errorf("Expected the permission denied error, got %v", err)
+	}
+}
+
+func TestAppRoleLogin(t *testing.T) {
+	v := newTestVault(t)
+	s := newTestStorage(t, backend.Config{
+		"address":     v.URL,
+		"auth_method": "approle",
+		"auth_mount":  "acme-approle",
+		"role_id":     "role",
+		"secret_id":   "secret",
+	})
+	if len(v.logins) != 1 {
+		t.Fatalf("Expected 1 login, got %v", v.logins)
+	}
+	login := v.logins[0]
+	if login["mount"] != "acme-approle" || login["role_id"] != "role" || login["secret_id"] != "secret" {
+		t.Errorf("Unexpected login %v", login)
+	}
+	if err := s.SaveAccount(backendtest.Account("www.example.com", "user@example.com")); err != nil {
+		t.Errorf("Expected save to succeed with the login token, got %v", err)
+	}
+}
+
+func TestKubernetesLogin(t *testing.T) {
+	v := newTestVault(t)
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenPath, []byte("service-account-jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestStorage(t, backend.Config{
+		"address":                    v.URL,
+		"auth_method":                "kubernetes",
+		"role":                       "acme",
+		"service_account_token_path": tokenPath,
+	})
+	if len(v.logins) != 1 {
+		t.Fatalf("Expected 1 login, got %v", v.logins)
+	}
+	login := v.logins[0]
+	if login["mount"] != "kubernetes" || login["role"] != "acme" || login["jwt"] != "service-account-jwt" {
+		t.Errorf("Unexpected login %v", login)
+	}
+	if err := s.SaveAccount(backendtest.Account("www.example.com", "user@example.com")); err != nil {
+		t.Errorf("Expected save to succeed with the login token, got %v", err)
+	}
+
+	if _, err := newBackend(backend.Config{
+		"address":                    v.URL,
+		"auth_method":                "kubernetes",
+		"service_account_token_path": filepath.Join(t.TempDir(), "missing"),
+	}); err == nil {
+		t.Error("Expected an error without service account token")
+	}
+}
+
+func TestRenewal(t *testing.T) {
+	retryInterval = 10 * time.Millisecond
+	t.Cleanup(func() { retryInterval = 30 * time.Second })
+	v := newTestVault(t)
+	v.leaseDuration = 2
+	newTestStorage(t, backend.Config{
+		"address":     v.URL,
+		"auth_method": "approle",
+		"role_id":     "role",
+		"secret_id":   "secret",
+	})
+
+	waitFor(t, "the token renewal", func() bool {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		return v.renewals > 0
+	})
+
+	// the token cannot be renewed anymore, the renewal logs in again and stops
+	// as the new token is not renewable.
+	v.mu.Lock()
+	v.revoked = true
+	v.mu.Unlock()
+	waitFor(t, "the login after the failed renewal", func() bool { return v.loginCount() == 2 })
+	time.Sleep(100 * time.Millisecond)
+	if logins := v.loginCount(); logins != 2 {
+		t.Errorf("Expected the renewal to stop with a non renewable token, got %d logins", logins)
+	}
+}
+
+func TestRenewalStopsOnDeniedLogin(t *testing.T) {
+	retryInterval = 10 * time.Millisecond
+	t.Cleanup(func() { retryInterval = 30 * time.Second })
+	v := newTestVault(t)
+	v.leaseDuration = 2
+	s := newTestStorage(t, backend.Config{
+		"address":     v.URL,
+		"auth_method": "approle",
+		"role_id":     "role",
+		"secret_id":   "secret",
+	})
+	output := &testLog{}
+	s.SetLogger(log.New(output, "", 0))
+
+	// the token and the secret ID are revoked, logging in again is denied.
+	v.mu.Lock()
+	v.revoked, v.deniedLogins = true, true
+	v.mu.Unlock()
+	waitFor(t, "the denied login", func() bool { return v.loginCount() == 2 })
+	time.Sleep(100 * time.Millisecond)
+	if logins := v.loginCount(); logins != 2 {
+		t.Errorf("Expected the renewal to stop once the login is denied, got %d logins", logins)
+	}
+	if out := output.String(); !strings.Contains(out, "permission denied") || !strings.Contains(out, "Stopping the renewal") {
+		t.Errorf("Expected the denied login logged with the logger of the backend, got %q", out)
+	}
+}
+
+func TestClose(t *testing.T) {
+	retryInterval = 10 * time.Millisecond
+	t.Cleanup(func() { retryInterval = 30 * time.Second })
+	v := newTestVault(t)
+	s := newTestStorage(t, backend.Config{
+		"address":     v.URL,
+		"auth_method": "approle",
+		"role_id":     "role",
+		"secret_id":   "secret",
+	})
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	// the non renewable token is replaced with a new login after 2/3 of its lease.
+	go s.renewToken(&api.Secret{Auth: &api.SecretAuth{LeaseDuration: 1}})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if logins := v.loginCount(); logins != 1 {
+		t.Errorf("Expected no login once closed, got %d logins", logins)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Expected closing twice to succeed, got %v", err)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := map[string]backend.Config{
+		"invalid insecure skip verify": {"insecure_skip_verify": "maybe"},
+		"missing ca file":              {"ca_file": "/does/not/exist"},
+		"invalid path template":        {"token": rootToken, "path": "{{.Domain"},
+		"unsupported auth method":      {"token": rootToken, "auth_method": "ldap"},
+	}
+	for name, config := range tests {
+		if _, err := newBackend(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
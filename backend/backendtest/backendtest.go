@@ -0,0 +1,93 @@
+// Package backendtest provides the conformance checks shared by the tests of the backends.
+package backendtest
+
+import (
+	"testing"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+// Account returns an account of the domain with a certificate.
+func Account(domain, email string) *types.Account {
+	return &types.Account{
+		Email: email,
+		DomainsCertificate: &types.DomainCertificate{
+			Domain:      &types.Domain{Main: domain},
+			Certificate: &types.Certificate{Domain: domain, Cert: []byte("cert-" + email), PrivateKey: []byte("key-" + email)},
+		},
+	}
+}
+
+// TestBackend checks that the backends returned by newBackend, replicas sharing the same store, save,
+// load and delete the accounts, and return backend.ErrConflict when saving an account modified
+// by another replica since it was loaded or saved.
+func TestBackend(t *testing.T, newBackend func(t *testing.T) backend.Interface) {
+	t.Run("SaveAndLoad", func(t *testing.T) {
+		testSaveAndLoad(t, newBackend)
+	})
+	t.Run("SaveConflict", func(t *testing.T) {
+		testSaveConflict(t, newBackend)
+	})
+}
+
+func testSaveAndLoad(t *testing.T, newBackend func(t *testing.T) backend.Interface) {
+	const domain = "www.example.com"
+	b := newBackend(t)
+	if account, err := b.LoadAccount(domain); err != nil || account != nil {
+		t.Fatalf("Expected no account, got %+v, %v", account, err)
+	}
+	if err := b.SaveAccount(Account(domain, "user@example.com")); err != nil {
+		t.Fatalf("Expected save to succeed, got %v", err)
+	}
+	if err := b.SaveAccount(Account(domain, "user@example.com")); err != nil {
+		t.Fatalf("Expected a second save to succeed, got %v", err)
+	}
+	for _, replica := range []backend.Interface{b, newBackend(t)} {
+		account, err := replica.LoadAccount(domain)
+		if err != nil || account == nil || account.Email != "user@example.com" {
+			t.Fatalf("Expected the account to be loaded, got %+v, %v", account, err)
+		}
+		if cert := account.DomainsCertificate.Certificate; cert == nil || string(cert.Cert) != "cert-user@example.com" {
+			t.Errorf("Expected the certificate to be loaded, got %+v", cert)
+		}
+	}
+	if err := backend.DeleteAccount(b, domain); err != nil {
+		t.Fatal(err)
+	}
+	if account, err := b.LoadAccount(domain); err != nil || account != nil {
+		t.Errorf("Expected the account to be deleted, got %+v, %v", account, err)
+	}
+	if err := backend.DeleteAccount(b, domain); err != nil {
+		t.Errorf("Expected deleting a missing account to succeed, got %v", err)
+	}
+}
+
+func testSaveConflict(t *testing.T, newBackend func(t *testing.T) backend.Interface) {
+	const domain = "conflict.example.com"
+	first, second := newBackend(t), newBackend(t)
+	if err := first.SaveAccount(Account(domain, "first@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.SaveAccount(Account(domain, "second@example.com")); err != backend.ErrConflict {
+		t.Fatalf("Expected a conflict saving over an account not loaded, got %v", err)
+	}
+	if _, err := second.LoadAccount(domain); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.SaveAccount(Account(domain, "first@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.SaveAccount(Account(domain, "second@example.com")); err != backend.ErrConflict {
+		t.Fatalf("Expected a conflict saving a stale account, got %v", err)
+	}
+	if _, err := second.LoadAccount(domain); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.SaveAccount(Account(domain, "second@example.com")); err != nil {
+		t.Fatalf("Expected save to succeed after reloading, got %v", err)
+	}
+	if account, err := first.LoadAccount(domain); err != nil || account == nil || account.Email != "second@example.com" {
+		t.Errorf("Expected the account of the second replica, got %+v, %v", account, err)
+	}
+}
@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jtblin/go-acme/types"
+)
+
+// ContextInterface represents a backend whose operations can be cancelled with a context.
+type ContextInterface interface {
+	// LoadAccountContext loads the account from the backend store.
+	LoadAccountContext(ctx context.Context, domain string) (*types.Account, error)
+	// Name returns the display name of the backend.
+	Name() string
+	// SaveAccountContext saves the account to the backend store.
+	SaveAccountContext(ctx context.Context, account *types.Account) error
+}
+
+// WithContext returns the backend as a ContextInterface. Backends which do not implement it
+// are adapted to return when the context is done, the underlying operation running on
+// in the background until it returns. The adapter implements the Deleter, Lister, Stater,
+// Locker and Watcher interfaces, returning ErrNotSupported if the backend does not.
+func WithContext(backend Interface) ContextInterface {
+	if b, ok := backend.(ContextInterface); ok {
+		return b
+	}
+	return &contextAdapter{backend}
+}
+
+// contextAdapter adapts an Interface to the ContextInterface.
+type contextAdapter struct {
+	Interface
+}
+
+// LoadAccountContext loads the account from the backend store until the context is done.
+func (b *contextAdapter) LoadAccountContext(ctx context.Context, domain string) (*types.Account, error) {
+	type result struct {
+		account *types.Account
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		account, err := b.LoadAccount(domain)
+		done <- result{account, err}
+	}()
+	select {
+	case r := <-done:
+		return r.account, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// SaveAccountContext saves the account to the backend store until the context is done.
+// A copy of the account is saved so that it can be modified once the context is done.
+func (b *contextAdapter) SaveAccountContext(ctx context.Context, account *types.Account) error {
+	saved, err := copyAccount(account)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- b.SaveAccount(saved)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DeleteAccount deletes the account of the domain if the backend implements Deleter.
+func (b *contextAdapter) DeleteAccount(domain string) error {
+	return DeleteAccount(b.Interface, domain)
+}
+
+// List lists the accounts if the backend implements Lister.
+func (b *contextAdapter) List(marker string, limit int) ([]Info, string, error) {
+	return List(b.Interface, marker, limit)
+}
+
+// Stat returns the metadata of the account of the domain if the backend implements Stater.
+func (b *contextAdapter) Stat(domain string) (*Info, error) {
+	return Stat(b.Interface, domain)
+}
+
+// Lock acquires the lock of the domain if the backend implements Locker.
+func (b *contextAdapter) Lock(ctx context.Context, domain string) (context.Context, func() error, error) {
+	l, ok := b.Interface.(Locker)
+	if !ok {
+		return nil, nil, ErrNotSupported
+	}
+	return l.Lock(ctx, domain)
+}
+
+// Watch watches the account of the domain if the backend implements Watcher.
+func (b *contextAdapter) Watch(ctx context.Context, domain string) (<-chan struct{}, error) {
+	w, ok := b.Interface.(Watcher)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return w.Watch(ctx, domain)
+}
+
+// copyAccount returns a deep copy of the account, as stored by the backends.
+func copyAccount(account *types.Account) (*types.Account, error) {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return nil, err
+	}
+	saved := &types.Account{DomainsCertificate: &types.DomainCertificate{}}
+	if err := json.Unmarshal(data, saved); err != nil {
+		return nil, err
+	}
+	saved.Logger = account.Logger
+	if account.DomainsCertificate != nil && saved.DomainsCertificate != nil {
+		saved.DomainsCertificate.TLSCert = account.DomainsCertificate.TLSCert
+	}
+	return saved, nil
+}
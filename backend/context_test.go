@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jtblin/go-acme/types"
+)
+
+// testBackend is a backend storing the last saved account, whose saves block until release is closed.
+type testBackend struct {
+	mu      sync.Mutex
+	account *types.Account
+	release chan struct{}
+	saved   chan struct{}
+}
+
+func (b *testBackend) LoadAccount(domain string) (*types.Account, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.account, nil
+}
+
+func (b *testBackend) Name() string { return "test" }
+
+func (b *testBackend) SaveAccount(account *types.Account) error {
+	if b.release != nil {
+		<-b.release
+	}
+	b.mu.Lock()
+	b.account = account
+	b.mu.Unlock()
+	if b.saved != nil {
+		close(b.saved)
+	}
+	return nil
+}
+
+// testCoordinatedBackend is a backend implementing the optional interfaces.
+type testCoordinatedBackend struct {
+	testBackend
+	calls []string
+}
+
+func (b *testCoordinatedBackend) DeleteAccount(domain string) error {
+	b.calls = append(b.calls, "delete "+domain)
+	return nil
+}
+
+func (b *testCoordinatedBackend) List(marker string, limit int) ([]Info, string, error) {
+	b.calls = append(b.calls, "list")
+	return []Info{{Domain: "www.example.com"}}, "", nil
+}
+
+func (b *testCoordinatedBackend) Stat(domain string) (*Info, error) {
+	b.calls = append(b.calls, "stat "+domain)
+	return &Info{Domain: domain}, nil
+}
+
+func (b *testCoordinatedBackend) Lock(ctx context.Context, domain string) (context.Context, func() error, error) {
+	b.calls = append(b.calls, "lock "+domain)
+	return ctx, func() error { return nil }, nil
+}
+
+func (b *testCoordinatedBackend) Watch(ctx context.Context, domain string) (<-chan struct{}, error) {
+	b.calls = append(b.calls, "watch "+domain)
+	return make(chan struct{}), nil
+}
+
+func TestSaveAccountContextTimeout(t *testing.T) {
+	b := &testBackend{release: make(chan struct{}), saved: make(chan struct{})}
+	account := &types.Account{
+		Email:              "first@example.com",
+		DomainsCertificate: &types.DomainCertificate{Domain: &types.Domain{Main: "www.example.com"}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := WithContext(b).SaveAccountContext(ctx, account); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the deadline exceeded, got %v", err)
+	}
+
+	// the account is modified by the caller while the save is still running.
+	account.Email = "second@example.com"
+	account.DomainsCertificate.Domain.Main = "api.example.com"
+	close(b.release)
+	select {
+	case <-b.saved:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the save to complete in the background")
+	}
+	saved, _ := b.LoadAccount("www.example.com")
+	if saved == account || saved.Email != "first@example.com" || saved.DomainsCertificate.Domain.Main != "www.example.com" {
+		t.Errorf("Expected a copy of the account as it was when saved, got %+v", saved)
+	}
+}
+
+func TestLoadAccountContextTimeout(t *testing.T) {
+	b := &testBackend{}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := WithContext(b).LoadAccountContext(ctx, "www.example.com"); err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline exceeded, got %v", err)
+	}
+}
+
+func TestContextAdapterForwarding(t *testing.T) {
+	b := &testCoordinatedBackend{}
+	adapter := WithContext(b)
+	if err := adapter.(Deleter).DeleteAccount("www.example.com"); err != nil {
+		t.Error(err)
+	}
+	if infos, _, err := adapter.(Lister).List("", 0); err != nil || len(infos) != 1 {
+		t.Errorf("Expected the accounts of the backend, got %v, %v", infos, err)
+	}
+	if info, err := adapter.(Stater).Stat("www.example.com"); err != nil || info.Domain != "www.example.com" {
+		t.Errorf("Expected the metadata of the backend, got %v, %v", info, err)
+	}
+	if _, _, err := adapter.(Locker).Lock(context.Background(), "www.example.com"); err != nil {
+		t.Error(err)
+	}
+	if _, err := adapter.(Watcher).Watch(context.Background(), "www.example.com"); err != nil {
+		t.Error(err)
+	}
+	expected := []string{"delete www.example.com", "list", "stat www.example.com", "lock www.example.com", "watch www.example.com"}
+	if len(b.calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, b.calls)
+	}
+	for i, call := range b.calls {
+		if call != expected[i] {
+			t.Errorf("Expected call %q, got %q", expected[i], call)
+		}
+	}
+}
+
+func TestContextAdapterNotSupported(t *testing.T) {
+	adapter := WithContext(&testBackend{})
+	if err := adapter.(Deleter).DeleteAccount("www.example.com"); err != ErrNotSupported {
+		t.Errorf("Expected delete not supported, got %v", err)
+	}
+	if _, _, err := adapter.(Lister).List("", 0); err != ErrNotSupported {
+		t.Errorf("Expected list not supported, got %v", err)
+	}
+	if _, err := adapter.(Stater).Stat("www.example.com"); err != ErrNotSupported {
+		t.Errorf("Expected stat not supported, got %v", err)
+	}
+	if _, _, err := adapter.(Locker).Lock(context.Background(), "www.example.com"); err != ErrNotSupported {
+		t.Errorf("Expected lock not supported, got %v", err)
+	}
+	if _, err := adapter.(Watcher).Watch(context.Background(), "www.example.com"); err != ErrNotSupported {
+		t.Errorf("Expected watch not supported, got %v", err)
+	}
+}
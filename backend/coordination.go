@@ -0,0 +1,26 @@
+package backend
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrConflict is returned by backends with optimistic concurrency when the account
+// was modified in the store since it was loaded or saved.
+var ErrConflict = errors.New("account modified concurrently in the backend store")
+
+// Locker is implemented by backends able to lock a domain across replicas
+// so that only one of them orders certificates at a time.
+type Locker interface {
+	// Lock blocks until the lock of the domain is acquired or the context is done, and returns
+	// a context derived from ctx which is done if the lock is lost, and the function releasing it.
+	Lock(ctx context.Context, domain string) (locked context.Context, unlock func() error, err error)
+}
+
+// Watcher is implemented by backends able to notify the changes of accounts
+// e.g. so that replicas load a certificate renewed by another one immediately.
+type Watcher interface {
+	// Watch sends on the channel when the account of the domain changes,
+	// until the context is done when the channel is closed.
+	Watch(ctx context.Context, domain string) (<-chan struct{}, error)
+}
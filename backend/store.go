@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotSupported is returned when a backend does not implement an optional operation.
+var ErrNotSupported = errors.New("operation not supported by backend")
+
+// Info holds the metadata of an account stored in a backend.
+type Info struct {
+	// Domain is the main domain of the account, used as its key.
+	Domain       string
+	LastModified time.Time
+	Size         int64
+}
+
+// Deleter is implemented by backends able to delete accounts.
+type Deleter interface {
+	// DeleteAccount deletes the account of the domain from the backend store.
+	// Deleting an account which does not exist is not an error.
+	DeleteAccount(domain string) error
+}
+
+// Lister is implemented by backends able to enumerate accounts.
+type Lister interface {
+	// List returns at most limit accounts in the backend store after the marker, and the marker
+	// of the next page which is empty on the last page. An empty marker starts from the first
+	// account and a limit of 0 or less uses the backend default.
+	List(marker string, limit int) ([]Info, string, error)
+}
+
+// Stater is implemented by backends able to return the metadata of accounts.
+type Stater interface {
+	// Stat returns the metadata of the account of the domain, or nil if it does not exist.
+	Stat(domain string) (*Info, error)
+}
+
+// DeleteAccount deletes the account of the domain if the backend implements Deleter.
+func DeleteAccount(backend Interface, domain string) error {
+	d, ok := backend.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	return d.DeleteAccount(domain)
+}
+
+// List lists the accounts if the backend implements Lister.
+func List(backend Interface, marker string, limit int) ([]Info, string, error) {
+	l, ok := backend.(Lister)
+	if !ok {
+		return nil, "", ErrNotSupported
+	}
+	return l.List(marker, limit)
+}
+
+// Stat returns the metadata of the account of the domain if the backend implements Stater.
+func Stat(backend Interface, domain string) (*Info, error) {
+	s, ok := backend.(Stater)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return s.Stat(domain)
+}
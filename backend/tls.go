@@ -0,0 +1,20 @@
+package backend
+
+import (
+	"crypto/tls"
+
+	"github.com/jtblin/go-acme/internal/tlsconfig"
+)
+
+// TLSConfig returns the TLS configuration of the "ca_file", "cert_file", "key_file" and
+// "insecure_skip_verify" keys, falling back to the environment variables with the prefix
+// e.g. "ETCD_" for ETCD_CA_FILE. It returns nil if none is set.
+func (c Config) TLSConfig(envPrefix string) (*tls.Config, error) {
+	caFile := c.Get("ca_file", envPrefix+"CA_FILE")
+	certFile, keyFile := c.Get("cert_file", envPrefix+"CERT_FILE"), c.Get("key_file", envPrefix+"KEY_FILE")
+	insecure := c.Get("insecure_skip_verify", envPrefix+"INSECURE_SKIP_VERIFY")
+	if caFile == "" && certFile == "" && keyFile == "" && insecure == "" {
+		return nil, nil
+	}
+	return tlsconfig.New(caFile, certFile, keyFile, insecure)
+}
@@ -0,0 +1,106 @@
+package acme
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+const (
+	http01ChallengePrefix = "/.well-known/acme-challenge/"
+	// httpTimeout is the read and write timeout of the HTTP-01 listener.
+	httpTimeout = 10 * time.Second
+)
+
+// httpChallenges holds the key authorizations of the HTTP-01 challenges in flight
+// and implements the acme.ChallengeProvider interface.
+type httpChallenges struct {
+	sync.RWMutex
+	keyAuths map[string]string
+}
+
+// Present makes the key authorization available for the token.
+func (h *httpChallenges) Present(domain, token, keyAuth string) error {
+	h.Lock()
+	defer h.Unlock()
+	if h.keyAuths == nil {
+		h.keyAuths = make(map[string]string)
+	}
+	h.keyAuths[acme.HTTP01ChallengePath(token)] = keyAuth
+	return nil
+}
+
+// CleanUp removes the key authorization for the token.
+func (h *httpChallenges) CleanUp(domain, token, keyAuth string) error {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.keyAuths, acme.HTTP01ChallengePath(token))
+	return nil
+}
+
+func (h *httpChallenges) keyAuth(path string) (string, bool) {
+	h.RLock()
+	defer h.RUnlock()
+	keyAuth, found := h.keyAuths[path]
+	return keyAuth, found
+}
+
+// HTTPHandler returns a handler answering the HTTP-01 challenges and passing other
+// requests to next. If next is nil, other requests are redirected to https.
+func (a *ACME) HTTPHandler(next http.Handler) http.Handler {
+	if next == nil {
+		next = http.HandlerFunc(redirectHTTPS)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, http01ChallengePrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		keyAuth, found := a.httpChallenges.keyAuth(r.URL.Path)
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+}
+
+// listenHTTP starts the http listener answering HTTP-01 challenges and redirecting to https,
+// closed when the context of CreateConfigContext is done.
+func (a *ACME) listenHTTP() error {
+	listener, err := net.Listen("tcp", a.HTTPAddress)
+	if err != nil {
+		return err
+	}
+	a.Logger.Printf("Listening for HTTP-01 challenges on %s\n", listener.Addr())
+	server := &http.Server{
+		Handler:           a.HTTPHandler(nil),
+		ReadHeaderTimeout: httpTimeout,
+		ReadTimeout:       httpTimeout,
+		WriteTimeout:      httpTimeout,
+	}
+	go func() {
+		<-a.ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.Logger.Printf("Error serving HTTP-01 challenges: %s\n", err.Error())
+		}
+	}()
+	return nil
+}
+
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
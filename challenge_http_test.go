@@ -0,0 +1,67 @@
+package acme
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+func TestListenHTTP(t *testing.T) {
+	// reserve a free port for the listener.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := &ACME{HTTPAddress: address, Logger: log.New(ioutil.Discard, "", 0), ctx: ctx}
+	if err := a.listenHTTP(); err != nil {
+		t.Fatal(err)
+	}
+	a.httpChallenges.Present("www.example.com", "token", "key-authorization")
+
+	resp, err := http.Get("http://" + address + acme.HTTP01ChallengePath("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "key-authorization" {
+		t.Errorf("Expected the key authorization to be served, got %q", body)
+	}
+
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the listener to be closed with the context")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// servedKeyAuth returns the key authorization served by the HTTP-01 handler for the token.
+func servedKeyAuth(a *ACME, token string) string {
+	rec := httptest.NewRecorder()
+	a.HTTPHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, acme.HTTP01ChallengePath(token), nil))
+	if rec.Code != http.StatusOK {
+		return ""
+	}
+	return strings.TrimSpace(rec.Body.String())
+}
@@ -0,0 +1,180 @@
+package acme
+
+import (
+	"fmt"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+// clientChallenges are the challenge types solved by the lego client, the ones not enabled are excluded.
+var clientChallenges = []acme.Challenge{acme.DNS01, acme.HTTP01, acme.TLSSNI01}
+
+// challenges returns the default challenge types.
+func (a *ACME) challenges() []acme.Challenge {
+	if len(a.Challenges) > 0 {
+		return a.Challenges
+	}
+	return []acme.Challenge{acme.DNS01}
+}
+
+// enabledChallenges returns the default challenge types and the types of the solvers.
+func (a *ACME) enabledChallenges() []acme.Challenge {
+	enabled := append([]acme.Challenge{}, a.challenges()...)
+	for _, solver := range a.solvers {
+		if !hasChallenge(enabled, solver.Challenge) {
+			enabled = append(enabled, solver.Challenge)
+		}
+	}
+	return enabled
+}
+
+// initChallenges initialises the providers of the enabled challenges.
+func (a *ACME) initChallenges() error {
+	// the providers of a previous configuration may hold the listeners needed.
+	a.closeDNSProviders(a.openProviders)
+	a.openProviders = nil
+	a.dnsProviders = make(map[string]acme.ChallengeProvider)
+	for _, challenge := range a.enabledChallenges() {
+		switch challenge {
+		case acme.DNS01:
+			if hasChallenge(a.challenges(), acme.DNS01) {
+				if err := a.initDNSProvider("", a.DNSProvider, a.DNSConfig); err != nil {
+					return err
+				}
+			}
+		case acme.HTTP01:
+			if a.HTTPAddress != "" {
+				if err := a.listenHTTP(); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("Unsupported challenge %q", challenge)
+		}
+	}
+	for key, solver := range a.solvers {
+		if solver.Challenge == acme.DNS01 {
+			if err := a.initDNSProvider(key, solver.dnsProvider(a.DNSProvider), solver.dnsConfig(a.DNSConfig)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// initDNSProvider initialises the named DNS provider for the solver key, or for
+// the domains without a solver if key is empty.
+func (a *ACME) initDNSProvider(key, name string, config *dnsprovider.Config) error {
+	if config == nil || config.Logger == nil {
+		withLogger := dnsprovider.Config{}
+		if config != nil {
+			withLogger = *config
+		}
+		withLogger.Logger = a.Logger
+		config = &withLogger
+	}
+	provider, err := dnsprovider.InitProvider(name, config)
+	if err != nil {
+		return err
+	}
+	a.openProviders = append(a.openProviders, provider)
+	propagation := a.Propagation.Merge(config)
+	if err = propagation.Validate(); err != nil {
+		return err
+	}
+	var resolvers []string
+	if propagation != nil {
+		resolvers = propagation.Resolvers
+	}
+	if len(a.ChallengeAliases) > 0 || a.FollowCNAME {
+		provider = newDelegatingProvider(provider, a.ChallengeAliases, a.FollowCNAME, resolvers)
+	}
+	provider = dnsprovider.WithPropagation(a.ctx, provider, propagation)
+	a.dnsProviders[key] = &journalingProvider{
+		ChallengeProvider: provider,
+		acme:              a,
+		key:               key,
+		resolvers:         dnsprovider.ResolverAddresses(resolvers),
+	}
+	return nil
+}
+
+// closeDNSProviders releases the resources held by the DNS providers.
+func (a *ACME) closeDNSProviders(providers []acme.ChallengeProvider) {
+	for _, provider := range providers {
+		if err := dnsprovider.Close(provider); err != nil {
+			a.Logger.Printf("Error closing DNS provider: %s\n", err.Error())
+		}
+	}
+}
+
+// challengeProvider returns the provider of the challenge.
+func (a *ACME) challengeProvider(challenge acme.Challenge) acme.ChallengeProvider {
+	if len(a.solvers) > 0 {
+		return &solverDispatcher{acme: a, challenge: challenge}
+	}
+	return a.defaultProvider(challenge)
+}
+
+// defaultProvider returns the provider of the challenge for domains without a solver.
+func (a *ACME) defaultProvider(challenge acme.Challenge) acme.ChallengeProvider {
+	switch challenge {
+	case acme.DNS01:
+		return a.dnsProviders[""]
+	case acme.HTTP01:
+		return &a.httpChallenges
+	default:
+		return nil
+	}
+}
+
+// excludedChallenges returns the challenges of the lego client which are not enabled.
+func excludedChallenges(enabled []acme.Challenge) []acme.Challenge {
+	var excluded []acme.Challenge
+	for _, challenge := range clientChallenges {
+		if !hasChallenge(enabled, challenge) {
+			excluded = append(excluded, challenge)
+		}
+	}
+	return excluded
+}
+
+// allowedChallenges returns the challenge types enabled for the domain.
+func (a *ACME) allowedChallenges(domain string) []acme.Challenge {
+	if _, solver, found := a.solver(domain); found {
+		return []acme.Challenge{solver.Challenge}
+	}
+	return a.challenges()
+}
+
+// orderChallenges returns the challenge types enabled on the lego client. The lego client chooses
+// the challenge type of every authorization of an order amongst the types enabled, so the names
+// of the certificate must have a type in common.
+func (a *ACME) orderChallenges() ([]acme.Challenge, error) {
+	common := a.enabledChallenges()
+	for _, name := range append([]string{a.Domain.Main}, a.Domain.SANs...) {
+		allowed := a.allowedChallenges(name)
+		var kept []acme.Challenge
+		for _, challenge := range common {
+			if hasChallenge(allowed, challenge) {
+				kept = append(kept, challenge)
+			}
+		}
+		common = kept
+	}
+	if len(common) == 0 {
+		return nil, fmt.Errorf("The names of %q have no challenge type in common", a.Domain.Main)
+	}
+	return common, nil
+}
+
+func hasChallenge(challenges []acme.Challenge, challenge acme.Challenge) bool {
+	for _, c := range challenges {
+		if c == challenge {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+	"github.com/jtblin/go-acme/types"
+)
+
+// testDNSProvider records the key authorizations presented by domain.
+type testDNSProvider struct {
+	mu        sync.Mutex
+	keyAuths  map[string]string
+	presented int
+	cleanedUp int
+}
+
+func (p *testDNSProvider) Present(domain, token, keyAuth string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keyAuths == nil {
+		p.keyAuths = make(map[string]string)
+	}
+	p.keyAuths[domain] = keyAuth
+	p.presented++
+	return nil
+}
+
+func (p *testDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.keyAuths, domain)
+	p.cleanedUp++
+	return nil
+}
+
+func (p *testDNSProvider) keyAuth(domain string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keyAuths[domain]
+}
+
+// standInDNS and otherStandInDNS are the providers of the "stand-in" and "other-stand-in" DNS providers.
+var standInDNS, otherStandInDNS = &testDNSProvider{}, &testDNSProvider{}
+
+func init() {
+	dnsprovider.RegisterProvider("stand-in", func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		return standInDNS, nil
+	})
+	dnsprovider.RegisterProvider("other-stand-in", func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		return otherStandInDNS, nil
+	})
+}
+
+func TestOrderChallenges(t *testing.T) {
+	internal := map[string]Solver{".internal.example.com": {Challenge: acme.DNS01}}
+	tests := []struct {
+		name       string
+		sans       []string
+		challenges []acme.Challenge
+		solvers    map[string]Solver
+		enabled    []acme.Challenge
+	}{
+		{"no solver", nil, []acme.Challenge{acme.HTTP01}, nil, []acme.Challenge{acme.HTTP01}},
+		{"solver type", []string{"api.internal.example.com"}, []acme.Challenge{acme.DNS01}, internal, []acme.Challenge{acme.DNS01}},
+		{"common type", []string{"api.example.com"}, []acme.Challenge{acme.DNS01, acme.HTTP01},
+			map[string]Solver{"api.example.com": {Challenge: acme.HTTP01}}, []acme.Challenge{acme.HTTP01}},
+		{"solver type enabled by default", []string{"api.internal.example.com"}, []acme.Challenge{acme.HTTP01, acme.DNS01}, internal,
+			[]acme.Challenge{acme.DNS01}},
+		{"no common type", []string{"api.internal.example.com"}, []acme.Challenge{acme.HTTP01}, internal, nil},
+	}
+	for _, test := range tests {
+		a := &ACME{
+			Domain:     &types.Domain{Main: "www.example.com", SANs: test.sans},
+			Challenges: test.challenges,
+			Solvers:    test.solvers,
+		}
+		if err := a.initSolvers(); err != nil {
+			t.Fatal(err)
+		}
+		enabled, err := a.orderChallenges()
+		if !reflect.DeepEqual(enabled, test.enabled) {
+			t.Errorf("%s: expected client challenges %v, got %v", test.name, test.enabled, enabled)
+		}
+		if (err != nil) != (test.enabled == nil) {
+			t.Errorf("%s: expected an error only without a common type, got %v", test.name, err)
+		}
+	}
+}
+
+func TestSolverKeys(t *testing.T) {
+	a := &ACME{Solvers: map[string]Solver{
+		"API.example.com":       {Challenge: acme.HTTP01},
+		".Internal.Example.com": {Challenge: acme.DNS01},
+	}}
+	if err := a.initSolvers(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		domain string
+		key    string
+	}{
+		{"api.example.com", "api.example.com"},
+		{"Api.Example.Com", "api.example.com"},
+		{"db.internal.example.com", ".internal.example.com"},
+		{"DB.INTERNAL.example.com", ".internal.example.com"},
+		{"www.example.com", ""},
+	}
+	for _, test := range tests {
+		if key, _, _ := a.solver(test.domain); key != test.key {
+			t.Errorf("%s: expected solver %q, got %q", test.domain, test.key, key)
+		}
+	}
+
+	a.Solvers["api.EXAMPLE.com"] = Solver{Challenge: acme.DNS01}
+	if err := a.initSolvers(); err == nil {
+		t.Error("Expected an error for the solvers with the same key in lower case")
+	}
+}
+
+func TestSolverDNSProviders(t *testing.T) {
+	// skips the propagation check of the stand-in records.
+	dnsprovider.InstallPreCheckDNS()
+	server := newTestACMEServer(t)
+	a := &ACME{
+		Email:       "user@example.com",
+		Domain:      &types.Domain{Main: "www.example.com", SANs: []string{"api.internal.example.com"}},
+		Backend:     &memoryBackend{},
+		DNSProvider: "stand-in",
+		CAServer:    server.directory(),
+		Solvers:     map[string]Solver{".internal.example.com": {Challenge: acme.DNS01, DNSProvider: "other-stand-in"}},
+		Propagation: &dnsprovider.Propagation{Skip: true},
+		Logger:      log.New(ioutil.Discard, "", 0),
+	}
+	server.validate = func(challengeType, domain, token, keyAuth string) bool {
+		provider := standInDNS
+		if domain == "api.internal.example.com" {
+			provider = otherStandInDNS
+		}
+		return challengeType == string(acme.DNS01) && provider.keyAuth(domain) == keyAuth
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := a.CreateConfigContext(ctx, &tls.Config{}); err != nil {
+		t.Fatalf("Expected certificate validated with the DNS provider of each name, got %v", err)
+	}
+	for _, name := range []string{"www.example.com", "api.internal.example.com"} {
+		if validated := server.validatedTypes(name); !reflect.DeepEqual(validated, []string{"dns-01"}) {
+			t.Errorf("Expected %s validated with dns-01, got %v", name, validated)
+		}
+	}
+}
+
+func TestMixedChallengeTypes(t *testing.T) {
+	a := &ACME{
+		Email:      "user@example.com",
+		Domain:     &types.Domain{Main: "www.example.com", SANs: []string{"api.internal.example.com"}},
+		Backend:    &memoryBackend{},
+		Challenges: []acme.Challenge{acme.HTTP01},
+		Solvers:    map[string]Solver{".internal.example.com": {Challenge: acme.DNS01, DNSProvider: "stand-in"}},
+		Logger:     log.New(ioutil.Discard, "", 0),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := a.CreateConfigContext(ctx, &tls.Config{}); err == nil || !strings.Contains(err.Error(), "no challenge type in common") {
+		t.Errorf("Expected an error for names without a challenge type in common, got %v", err)
+	}
+}
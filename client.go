@@ -0,0 +1,57 @@
+package acme
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/types"
+)
+
+// certificateKeyType is the type of the certificate private keys.
+var certificateKeyType = acme.RSA4096
+
+// caServerURL returns the url of the CA directory.
+func (a *ACME) caServerURL() string {
+	if len(a.CAServer) > 0 {
+		return a.CAServer
+	}
+	return defaultCAServer
+}
+
+// acmeClient returns the client of the CA, registering the account with the CA if needed.
+func (a *ACME) acmeClient(ctx context.Context, account *types.Account) (*acme.Client, error) {
+	a.mu.RLock()
+	client := a.client
+	a.mu.RUnlock()
+	if client != nil {
+		return client, nil
+	}
+	client, err := a.buildACMEClient(account)
+	if err != nil {
+		return nil, err
+	}
+	if account.Registration == nil {
+		a.Logger.Printf("Registering ACME account with %q...\n", a.caServerURL())
+		// New users need to register.
+		reg, err := client.Register()
+		if err != nil {
+			return nil, err
+		}
+		account.Registration = reg
+
+		// The client has a URL to the current Let's Encrypt Subscriber
+		// Agreement. The user needs to agree to it.
+		if err = client.AgreeToTOS(); err != nil {
+			return nil, err
+		}
+		if err = a.saveAccount(ctx, account); err != nil {
+			return nil, fmt.Errorf("Error Saving ACME account %+v: %s", account, err.Error())
+		}
+	}
+	a.mu.Lock()
+	a.client = client
+	a.mu.Unlock()
+	return client, nil
+}
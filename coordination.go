@@ -0,0 +1,145 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+// lockDomain acquires the storage backend lock of the main domain if supported, so that only
+// one replica orders certificates at a time, then swaps in the account stored by another replica
+// while waiting. It returns the context of the operations made holding the lock, done if the
+// lock is lost, the function releasing the lock, and whether a lock is held.
+func (a *ACME) lockDomain(ctx context.Context) (context.Context, func(), bool, error) {
+	locker, ok := a.storage.(backend.Locker)
+	if !ok {
+		return ctx, func() {}, false, nil
+	}
+	locked, unlock, err := locker.Lock(ctx, a.Domain.Main)
+	if err == backend.ErrNotSupported {
+		return ctx, func() {}, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	release := func() {
+		if err := unlock(); err != nil {
+			a.Logger.Printf("Error releasing lock for %q: %s\n", a.Domain.Main, err.Error())
+		}
+	}
+
+	account, err := a.loadAccount(locked, a.Domain.Main)
+	if err == nil && hasCertificate(account) {
+		err = a.swapAccount(account)
+	}
+	if err != nil {
+		release()
+		return nil, nil, false, err
+	}
+	return locked, release, true, nil
+}
+
+// watch reloads the certificate when the storage backend notifies a change of the account
+// e.g. after another replica renewed it.
+func (a *ACME) watch(ctx context.Context) error {
+	watcher, ok := a.storage.(backend.Watcher)
+	if !ok {
+		return nil
+	}
+	changes, err := watcher.Watch(ctx, a.Domain.Main)
+	if err == backend.ErrNotSupported {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range changes {
+			if err := a.Reload(); err != nil {
+				a.Logger.Printf("Error reloading ACME certificate for %q: %s\n", a.Domain.Main, err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+func hasCertificate(account *types.Account) bool {
+	return account != nil && account.DomainsCertificate != nil && account.DomainsCertificate.Certificate != nil &&
+		len(account.DomainsCertificate.Certificate.Cert) > 0
+}
+
+// mergeAccount merges the account stored by another replica into the account being saved,
+// so that the changes of the other replica are not overwritten: the certificate expiring last
+// is kept, and the orders and challenges of the other replica are added,
+// as well as its registration when the account is not registered yet.
+func (a *ACME) mergeAccount(account, stored *types.Account) {
+	if stored == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if hasCertificate(stored) && stored.DomainsCertificate.Init() == nil &&
+		notAfter(stored.DomainsCertificate).After(notAfter(account.DomainsCertificate)) {
+		a.Logger.Printf("Keeping the newer ACME certificate stored for %q\n", a.Domain.Main)
+		account.DomainsCertificate.Certificate = stored.DomainsCertificate.Certificate
+		account.DomainsCertificate.TLSCert = stored.DomainsCertificate.TLSCert
+	}
+
+	orders := make(map[string]bool, len(account.Orders))
+	for _, o := range account.Orders {
+		orders[orderKey(o)] = true
+	}
+	merged := append([]*types.Order{}, account.Orders...)
+	for _, o := range stored.Orders {
+		if !orders[orderKey(o)] {
+			merged = append(merged, o)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+	account.Orders = merged
+
+	if account.Registration == nil && stored.Registration != nil {
+		account.Registration = stored.Registration
+		account.PrivateKey = stored.PrivateKey
+	}
+
+	// the journal of this instance is up to date, only the records of the other replicas are added.
+	challenges := append([]*types.Challenge{}, account.Challenges...)
+	for _, c := range stored.Challenges {
+		if c.Owner != "" && c.Owner == a.instanceID {
+			continue
+		}
+		found := false
+		for _, own := range account.Challenges {
+			if own.Provider == c.Provider && own.Domain == c.Domain && own.KeyAuth == c.KeyAuth {
+				found = true
+				break
+			}
+		}
+		if !found {
+			challenges = append(challenges, c)
+		}
+	}
+	account.Challenges = challenges
+}
+
+// notAfter returns the expiry of the leaf certificate, zero if there is none.
+func notAfter(dc *types.DomainCertificate) time.Time {
+	if dc == nil || dc.TLSCert == nil || len(dc.TLSCert.Certificate) == 0 {
+		return time.Time{}
+	}
+	leaf, err := x509.ParseCertificate(dc.TLSCert.Certificate[0])
+	if err != nil {
+		return time.Time{}
+	}
+	return leaf.NotAfter
+}
+
+func orderKey(order *types.Order) string {
+	return fmt.Sprintf("%s %s %d", order.CAServer, order.Account, order.Time.UnixNano())
+}
@@ -0,0 +1,166 @@
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"gopkg.in/square/go-jose.v1"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+// conflictingBackend returns backend.ErrConflict on the first saves, as if another replica
+// saved the account meanwhile.
+type conflictingBackend struct {
+	memoryBackend
+	conflicts int
+	loads     int
+	// concurrent, if set, is the account saved by the other replica on the first conflict.
+	concurrent *types.Account
+}
+
+func (b *conflictingBackend) LoadAccountContext(ctx context.Context, domain string) (*types.Account, error) {
+	b.loads++
+	return b.LoadAccount(domain)
+}
+
+func (b *conflictingBackend) SaveAccountContext(ctx context.Context, account *types.Account) error {
+	if b.conflicts > 0 {
+		b.conflicts--
+		if b.concurrent != nil {
+			if err := b.SaveAccount(b.concurrent); err != nil {
+				return err
+			}
+			b.concurrent = nil
+		}
+		return backend.ErrConflict
+	}
+	return b.SaveAccount(account)
+}
+
+func TestSaveAccountConflict(t *testing.T) {
+	tests := []struct {
+		conflicts int
+		loads     int
+		err       error
+	}{
+		{0, 0, nil},
+		{2, 2, nil},
+		{maxSaveConflicts + 1, maxSaveConflicts, backend.ErrConflict},
+	}
+	for _, test := range tests {
+		b := &conflictingBackend{conflicts: test.conflicts}
+		a := &ACME{Domain: &types.Domain{Main: "www.example.com"}, Logger: log.New(ioutil.Discard, "", 0)}
+		a.storage = backend.WithContext(b)
+		account := &types.Account{Email: "user@example.com", DomainsCertificate: &types.DomainCertificate{Domain: a.Domain}}
+
+		err := a.saveAccount(context.Background(), account)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%d conflicts: expected error %v, got %v", test.conflicts, test.err, err)
+		}
+		if b.loads != test.loads {
+			t.Errorf("%d conflicts: expected %d reloads, got %d", test.conflicts, test.loads, b.loads)
+		}
+		if stored, _ := b.LoadAccount("www.example.com"); (stored != nil) != (test.err == nil) {
+			t.Errorf("%d conflicts: expected the account saved %t, got %+v", test.conflicts, test.err == nil, stored)
+		}
+	}
+}
+
+// testCertificate returns a self signed certificate of the domain expiring at expiration.
+func testCertificate(t *testing.T, domain string, expiration time.Time) *types.Certificate {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := generateDerCert(privateKey, expiration, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.Certificate{
+		Domain:     domain,
+		Cert:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}),
+	}
+}
+
+func TestSaveAccountConflictMerge(t *testing.T) {
+	now := time.Now()
+	accountKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &ACME{Domain: &types.Domain{Main: "www.example.com"}, Logger: log.New(ioutil.Discard, "", 0), instanceID: "local"}
+	account := &types.Account{
+		Email:      "user@example.com",
+		Challenges: []*types.Challenge{{Domain: "www.example.com", KeyAuth: "local", Owner: "local"}},
+		Orders:     testOrders(1, now, true, true, "www.example.com"),
+	}
+	account.DomainsCertificate = &types.DomainCertificate{}
+	if err := account.DomainsCertificate.AddCertificate(testCertificate(t, "www.example.com", now.Add(30*24*time.Hour)), a.Domain); err != nil {
+		t.Fatal(err)
+	}
+
+	// the other replica renewed the certificate meanwhile, and journaled a record.
+	newer := testCertificate(t, "www.example.com", now.Add(90*24*time.Hour))
+	concurrent := &types.Account{
+		Email:      "user@example.com",
+		PrivateKey: x509.MarshalPKCS1PrivateKey(accountKey),
+		Registration: &acme.RegistrationResource{
+			URI:  "https://ca.example.com/acct/1",
+			Body: acme.Registration{Key: jose.JsonWebKey{Key: &accountKey.PublicKey}},
+		},
+		Challenges:         []*types.Challenge{{Domain: "www.example.com", KeyAuth: "cleaned-up", Owner: "local"}, {Domain: "www.example.com", KeyAuth: "other", Owner: "other"}},
+		DomainsCertificate: &types.DomainCertificate{Certificate: newer, Domain: a.Domain},
+		Orders:             testOrders(1, now.Add(-time.Minute), true, true, "www.example.com"),
+	}
+	b := &conflictingBackend{conflicts: 1, concurrent: concurrent}
+	a.storage = backend.WithContext(b)
+
+	if err := a.saveAccount(context.Background(), account); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := b.LoadAccount("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.DomainsCertificate.Certificate.Cert) != string(newer.Cert) {
+		t.Error("Expected the newer certificate of the other replica to survive")
+	}
+	if expiry := notAfter(account.DomainsCertificate); expiry.Before(now.Add(89 * 24 * time.Hour)) {
+		t.Error("Expected the newer certificate served")
+	}
+	if len(stored.Orders) != 2 || !stored.Orders[0].Time.Before(stored.Orders[1].Time) {
+		t.Errorf("Expected the orders of both replicas in order, got %+v", stored.Orders)
+	}
+	if stored.Registration == nil || stored.Registration.URI != "https://ca.example.com/acct/1" || len(stored.PrivateKey) == 0 {
+		t.Errorf("Expected the registration of the other replica, got %+v", stored.Registration)
+	}
+	var keyAuths []string
+	for _, c := range stored.Challenges {
+		keyAuths = append(keyAuths, c.KeyAuth)
+	}
+	if len(keyAuths) != 2 || keyAuths[0] != "local" || keyAuths[1] != "other" {
+		t.Errorf("Expected the own journal and the records of the other replica, got %v", keyAuths)
+	}
+
+	// an older stored certificate does not replace the one being saved.
+	older := testCertificate(t, "www.example.com", now.Add(24*time.Hour))
+	b.conflicts, b.concurrent = 1, &types.Account{DomainsCertificate: &types.DomainCertificate{Certificate: older, Domain: a.Domain}}
+	if err := a.saveAccount(context.Background(), account); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := b.LoadAccount("www.example.com"); string(stored.DomainsCertificate.Certificate.Cert) != string(newer.Cert) {
+		t.Error("Expected the certificate expiring last kept")
+	}
+}
@@ -11,12 +11,12 @@ echo "mode: count" > coverage.out
 # Initialize error tracking
 ERROR=""
 
-declare -a packages=('backend' 'backend/backends/fs' 'backend/backends/s3' 'backend/backends/null' 'types');
+declare -a packages=($(go list ./... | grep -v /examples/));
 
 # Test each package and append coverage profile info to coverage.out
 for pkg in "${packages[@]}"
 do
-    go test -v -covermode=count -coverprofile=coverage_tmp.out "github.com/jtblin/go-acme/$pkg" || ERROR="Error testing $pkg"
+    go test -v -covermode=count -coverprofile=coverage_tmp.out "$pkg" || ERROR="Error testing $pkg"
     tail -n +2 coverage_tmp.out >> coverage.out 2> /dev/null ||:
 done
 
@@ -0,0 +1,107 @@
+package acme
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const challengeLabel = "_acme-challenge."
+
+// delegatingProvider presents the challenge records at the target of the _acme-challenge
+// delegation and implements the acme.ChallengeProviderTimeout interface.
+type delegatingProvider struct {
+	acme.ChallengeProvider
+	aliases     map[string]string
+	followCNAME bool
+	// resolvers are the resolvers of the CNAME lookups (default acme.RecursiveNameservers).
+	resolvers []string
+	// targets holds the targets of the challenges in flight by FQDN.
+	targets map[string]string
+	mu      sync.Mutex
+}
+
+func newDelegatingProvider(provider acme.ChallengeProvider, aliases map[string]string, followCNAME bool, resolvers []string) *delegatingProvider {
+	return &delegatingProvider{
+		ChallengeProvider: provider,
+		aliases:           aliases,
+		followCNAME:       followCNAME,
+		resolvers:         resolvers,
+		targets:           make(map[string]string),
+	}
+}
+
+// Present presents the challenge record at the delegation target.
+func (p *delegatingProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	target, err := p.target(domain, fqdn)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.targets[fqdn] = target
+	p.mu.Unlock()
+
+	switch provider := dnsprovider.Unwrap(p.ChallengeProvider).(type) {
+	case dnsprovider.RecordProvider:
+		if target != fqdn {
+			return provider.PresentRecord(target, value)
+		}
+	default:
+		if target != fqdn {
+			if !strings.HasPrefix(target, challengeLabel) {
+				return fmt.Errorf("DNS provider cannot present the challenge record at %s delegated from %s", target, fqdn)
+			}
+			domain = acme.UnFqdn(strings.TrimPrefix(target, challengeLabel))
+		}
+	}
+	return p.ChallengeProvider.Present(domain, token, keyAuth)
+}
+
+// CleanUp removes the challenge record at the delegation target.
+func (p *delegatingProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	p.mu.Lock()
+	target, found := p.targets[fqdn]
+	delete(p.targets, fqdn)
+	p.mu.Unlock()
+	if !found {
+		// e.g. records journaled by a previous run.
+		var err error
+		if target, err = p.target(domain, fqdn); err != nil {
+			return err
+		}
+	}
+	if target == fqdn {
+		return p.ChallengeProvider.CleanUp(domain, token, keyAuth)
+	}
+	if provider, ok := dnsprovider.Unwrap(p.ChallengeProvider).(dnsprovider.RecordProvider); ok {
+		return provider.CleanUpRecord(target, value)
+	}
+	return p.ChallengeProvider.CleanUp(acme.UnFqdn(strings.TrimPrefix(target, challengeLabel)), token, keyAuth)
+}
+
+// Timeout returns the timeout and interval of the provider.
+func (p *delegatingProvider) Timeout() (timeout, interval time.Duration) {
+	if provider, ok := p.ChallengeProvider.(acme.ChallengeProviderTimeout); ok {
+		return provider.Timeout()
+	}
+	return 60 * time.Second, 2 * time.Second
+}
+
+// target returns the FQDN where the challenge record must be presented.
+func (p *delegatingProvider) target(domain, fqdn string) (string, error) {
+	if alias, found := p.aliases[strings.TrimPrefix(domain, "*.")]; found {
+		return dns.Fqdn(challengeLabel + alias), nil
+	}
+	if p.followCNAME {
+		return dnsprovider.FollowCNAME(fqdn, p.resolvers)
+	}
+	return fqdn, nil
+}
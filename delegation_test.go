@@ -0,0 +1,147 @@
+package acme
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/webhook"
+)
+
+// testRecordWebhook is a webhook recording the FQDNs of the records posted by path.
+type testRecordWebhook struct {
+	mu    sync.Mutex
+	fqdns map[string][]string
+}
+
+func (w *testRecordWebhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	var record struct {
+		FQDN string `json:"fqdn"`
+	}
+	json.NewDecoder(r.Body).Decode(&record)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fqdns[r.URL.Path] = append(w.fqdns[r.URL.Path], record.FQDN)
+}
+
+// posted returns the FQDNs of the records posted to the path.
+func (w *testRecordWebhook) posted(path string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.fqdns[path]
+}
+
+// newTestRecordProvider returns the webhook record provider wrapped with a propagation timeout.
+func newTestRecordProvider(t *testing.T) (acme.ChallengeProvider, *testRecordWebhook) {
+	w := &testRecordWebhook{fqdns: make(map[string][]string)}
+	server := httptest.NewServer(w)
+	t.Cleanup(server.Close)
+	provider, err := dnsprovider.InitProvider("webhook", &dnsprovider.Config{
+		Credentials: map[string]string{"present_url": server.URL + "/present", "cleanup_url": server.URL + "/cleanup"},
+		Propagation: &dnsprovider.Propagation{Timeout: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, w
+}
+
+// startCNAMEResolver starts a DNS server on localhost answering the CNAME records
+// and returns its address.
+func startCNAMEResolver(t *testing.T, cnames map[string]string) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if target, found := cnames[strings.ToLower(r.Question[0].Name)]; found {
+			m.Answer = append(m.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+				Target: target,
+			})
+		}
+		w.WriteMsg(m)
+	})
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestDelegatedRecordProvider(t *testing.T) {
+	provider, w := newTestRecordProvider(t)
+	p := newDelegatingProvider(provider, map[string]string{"www.example.com": "www.example-challenges.net"}, false, nil)
+	for _, domain := range []string{"www.example.com", "*.www.example.com"} {
+		if err := p.Present(domain, "token", "key-auth-"+domain); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.CleanUp(domain, "token", "key-auth-"+domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{"_acme-challenge.www.example-challenges.net.", "_acme-challenge.www.example-challenges.net."}
+	for _, path := range []string{"/present", "/cleanup"} {
+		if fqdns := w.posted(path); strings.Join(fqdns, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected the records posted to %s at the alias target %v, got %v", path, expected, fqdns)
+		}
+	}
+}
+
+func TestDelegatedChallengeProvider(t *testing.T) {
+	provider := &testDNSProvider{}
+	p := newDelegatingProvider(provider, map[string]string{"www.example.com": "www.example-challenges.net"}, false, nil)
+	if err := p.Present("www.example.com", "token", "key-auth"); err != nil {
+		t.Fatal(err)
+	}
+	if provider.keyAuth("www.example-challenges.net") != "key-auth" {
+		t.Errorf("Expected the challenge presented for the alias, got %v", provider.keyAuths)
+	}
+	if err := p.CleanUp("www.example.com", "token", "key-auth"); err != nil {
+		t.Fatal(err)
+	}
+	if provider.cleanedUp != 1 || provider.keyAuth("www.example-challenges.net") != "" {
+		t.Errorf("Expected the challenge of the alias cleaned up, got %v", provider.keyAuths)
+	}
+}
+
+func TestFollowCNAMEResolvers(t *testing.T) {
+	resolver := startCNAMEResolver(t, map[string]string{
+		"_acme-challenge.www.example.com.": "www.challenges.example.net.",
+		"_acme-challenge.api.example.com.": "_acme-challenge.api.example.net.",
+	})
+	record, w := newTestRecordProvider(t)
+	p := newDelegatingProvider(record, nil, true, []string{resolver})
+	if err := p.Present("www.example.com", "token", "key-auth"); err != nil {
+		t.Fatal(err)
+	}
+	if fqdns := w.posted("/present"); len(fqdns) != 1 || fqdns[0] != "www.challenges.example.net." {
+		t.Errorf("Expected the record presented at the CNAME target found with the configured resolver, got %v", fqdns)
+	}
+
+	// the other providers can only present records at _acme-challenge names.
+	provider := &testDNSProvider{}
+	p = newDelegatingProvider(provider, nil, true, []string{resolver})
+	if err := p.Present("api.example.com", "token", "key-auth"); err != nil {
+		t.Fatal(err)
+	}
+	if provider.keyAuth("api.example.net") != "key-auth" {
+		t.Errorf("Expected the challenge presented for the CNAME target, got %v", provider.keyAuths)
+	}
+	err := p.Present("www.example.com", "token", "key-auth")
+	if err == nil || !strings.Contains(err.Error(), "cannot present the challenge record at www.challenges.example.net.") {
+		t.Errorf("Expected an error presenting at a target other than an _acme-challenge name, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package dnsprovider
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jtblin/go-logger"
+	"github.com/xenolf/lego/acme"
+)
+
+// Config holds the configuration of a DNS provider.
+// Unset values fall back to the environment variables of the provider.
+type Config struct {
+	// Credentials holds the provider credentials by key e.g. "api_key".
+	Credentials map[string]string
+	// Zone is the DNS zone of the challenge records e.g. the route53 hosted zone id.
+	Zone string
+	// TTL is the TTL of the challenge records, for providers that support it.
+	TTL int
+	// Propagation holds the propagation check options of the provider, overriding ACME.Propagation.
+	Propagation *Propagation
+	// Logger is the logger of the provider (default ACME.Logger).
+	Logger logger.Interface
+}
+
+// Get returns the credential for the key, or the value of the environment variable
+// if the credential is not set.
+func (c *Config) Get(key, env string) string {
+	if c != nil {
+		if value, found := c.Credentials[key]; found && value != "" {
+			return value
+		}
+	}
+	return os.Getenv(env)
+}
+
+// HasCredentials returns true if credentials are set.
+func (c *Config) HasCredentials() bool {
+	return c != nil && len(c.Credentials) > 0
+}
+
+// Field is an optional field of Config only supported by some providers.
+type Field string
+
+const (
+	// FieldTTL is the Config.TTL field.
+	FieldTTL Field = "TTL"
+	// FieldZone is the Config.Zone field.
+	FieldZone Field = "Zone"
+)
+
+// CheckFields returns an error if the config sets an optional field which is not supported.
+func (c *Config) CheckFields(supported ...Field) error {
+	if c == nil {
+		return nil
+	}
+	set := map[Field]bool{FieldTTL: c.TTL != 0, FieldZone: c.Zone != ""}
+	for _, field := range []Field{FieldTTL, FieldZone} {
+		if !set[field] {
+			continue
+		}
+		found := false
+		for _, f := range supported {
+			found = found || f == field
+		}
+		if !found {
+			return fmt.Errorf("%s is not supported by the provider", field)
+		}
+	}
+	return nil
+}
+
+// timeoutProvider overrides the propagation timeout of a provider
+// and implements the acme.ChallengeProviderTimeout interface.
+type timeoutProvider struct {
+	acme.ChallengeProvider
+	timeout  time.Duration
+	interval time.Duration
+}
+
+// Unwrap returns the wrapped provider.
+func (p *timeoutProvider) Unwrap() acme.ChallengeProvider {
+	return p.ChallengeProvider
+}
+
+// Timeout returns the propagation timeout and polling interval.
+func (p *timeoutProvider) Timeout() (timeout, interval time.Duration) {
+	return p.timeout, p.interval
+}
+
+// WithTimeout returns the provider with the propagation timeout and polling interval
+// of the config if set.
+func WithTimeout(provider acme.ChallengeProvider, config *Config) acme.ChallengeProvider {
+	if config == nil || config.Propagation == nil || (config.Propagation.Timeout == 0 && config.Propagation.PollingInterval == 0) {
+		return provider
+	}
+	timeout, interval := 60*time.Second, 2*time.Second
+	if p, ok := provider.(acme.ChallengeProviderTimeout); ok {
+		timeout, interval = p.Timeout()
+	}
+	if config.Propagation.Timeout > 0 {
+		timeout = config.Propagation.Timeout
+	}
+	if config.Propagation.PollingInterval > 0 {
+		interval = config.Propagation.PollingInterval
+	}
+	return &timeoutProvider{ChallengeProvider: provider, timeout: timeout, interval: interval}
+}
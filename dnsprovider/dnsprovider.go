@@ -0,0 +1,92 @@
+package dnsprovider
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// All registered providers.
+var providersMutex sync.Mutex
+var providers = make(map[string]Factory)
+
+// Factory is a function that returns a DNS challenge provider configured with config,
+// falling back to environment variables for unset values. The config may be nil.
+type Factory func(config *Config) (acme.ChallengeProvider, error)
+
+// RegisterProvider registers a DNS provider.
+func RegisterProvider(name string, provider Factory) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	if _, found := providers[name]; found {
+		panic(fmt.Sprintf("DNS provider %q was registered twice\n", name))
+	}
+	providers[name] = provider
+}
+
+// GetProvider creates an instance of the named provider, or nil if
+// the name is not known.  The error return is only used if the named provider
+// was known but failed to initialize.
+func GetProvider(name string, config *Config) (acme.ChallengeProvider, error) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	f, found := providers[name]
+	if !found {
+		return nil, nil
+	}
+	provider, err := f(config)
+	if err != nil {
+		return nil, err
+	}
+	return WithTimeout(provider, config), nil
+}
+
+// InitProvider creates an instance of the named provider.
+func InitProvider(name string, config *Config) (acme.ChallengeProvider, error) {
+	if name == "" {
+		return nil, fmt.Errorf("DNS provider name must be provided")
+	}
+
+	provider, err := GetProvider(name, config)
+	if err != nil {
+		return nil, fmt.Errorf("Could not init DNS provider %q: %v", name, err)
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("Unknown DNS provider %q", name)
+	}
+
+	return provider, nil
+}
+
+// RecordProvider is implemented by providers able to present the challenge record
+// at any FQDN e.g. when the _acme-challenge name is delegated with a CNAME.
+type RecordProvider interface {
+	// PresentRecord creates the TXT record with the value at the FQDN.
+	PresentRecord(fqdn, value string) error
+	// CleanUpRecord removes the TXT record with the value at the FQDN.
+	CleanUpRecord(fqdn, value string) error
+}
+
+// Close releases the resources held by the provider e.g. the listeners of the
+// embedded DNS server, if it implements io.Closer.
+func Close(provider acme.ChallengeProvider) error {
+	if c, ok := Unwrap(provider).(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Unwrap returns the provider wrapped by the timeout and propagation options, following
+// the providers implementing Unwrap() acme.ChallengeProvider e.g. to find whether it is
+// a RecordProvider.
+func Unwrap(provider acme.ChallengeProvider) acme.ChallengeProvider {
+	for {
+		wrapper, ok := provider.(interface{ Unwrap() acme.ChallengeProvider })
+		if !ok {
+			return provider
+		}
+		provider = wrapper.Unwrap()
+	}
+}
@@ -0,0 +1,136 @@
+package dnsprovider
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// closingProvider records whether it was closed.
+type closingProvider struct {
+	testProvider
+	closed bool
+}
+
+func (p *closingProvider) Close() error {
+	p.closed = true
+	return nil
+}
+
+func init() {
+	RegisterProvider("failing", func(config *Config) (acme.ChallengeProvider, error) {
+		return nil, errors.New("missing credentials")
+	})
+	RegisterProvider("ttl-only", func(config *Config) (acme.ChallengeProvider, error) {
+		if err := config.CheckFields(FieldTTL); err != nil {
+			return nil, err
+		}
+		return testProvider{}, nil
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		err    string
+	}{
+		{"", nil, "DNS provider name must be provided"},
+		{"unknown", nil, `Unknown DNS provider "unknown"`},
+		{"failing", nil, `Could not init DNS provider "failing": missing credentials`},
+		{"test", nil, ""},
+		{"ttl-only", &Config{TTL: 60}, ""},
+		{"ttl-only", &Config{Zone: "example.com"}, `Could not init DNS provider "ttl-only": Zone is not supported by the provider`},
+	}
+	for _, test := range tests {
+		provider, err := InitProvider(test.name, test.config)
+		if test.err == "" {
+			if err != nil || provider == nil {
+				t.Errorf("%q: expected a provider, got %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%q: expected error %q, got %v", test.name, test.err, err)
+		}
+	}
+
+	if provider, err := GetProvider("unknown", nil); provider != nil || err != nil {
+		t.Errorf("Expected no provider nor error for an unknown name, got %v, %v", provider, err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), `"test" was registered twice`) {
+			t.Errorf("Expected a panic registering a provider twice, got %v", r)
+		}
+	}()
+	RegisterProvider("test", func(config *Config) (acme.ChallengeProvider, error) {
+		return testProvider{}, nil
+	})
+}
+
+func TestConfigGet(t *testing.T) {
+	os.Setenv("TEST_DNS_API_KEY", "env-key")
+	defer os.Unsetenv("TEST_DNS_API_KEY")
+	tests := []struct {
+		config   *Config
+		expected string
+	}{
+		{nil, "env-key"},
+		{&Config{}, "env-key"},
+		{&Config{Credentials: map[string]string{"api_key": ""}}, "env-key"},
+		{&Config{Credentials: map[string]string{"other": "value"}}, "env-key"},
+		{&Config{Credentials: map[string]string{"api_key": "config-key"}}, "config-key"},
+	}
+	for _, test := range tests {
+		if actual := test.config.Get("api_key", "TEST_DNS_API_KEY"); actual != test.expected {
+			t.Errorf("Expected %q for %+v, got %q", test.expected, test.config, actual)
+		}
+	}
+	if actual := (&Config{}).Get("api_key", "TEST_DNS_UNSET"); actual != "" {
+		t.Errorf("Expected no value, got %q", actual)
+	}
+	if (*Config)(nil).HasCredentials() || (&Config{}).HasCredentials() {
+		t.Error("Expected no credentials")
+	}
+}
+
+func TestCheckFields(t *testing.T) {
+	tests := []struct {
+		config    *Config
+		supported []Field
+		err       bool
+	}{
+		{nil, nil, false},
+		{&Config{Credentials: map[string]string{"api_key": "key"}}, nil, false},
+		{&Config{TTL: 60}, nil, true},
+		{&Config{Zone: "example.com"}, nil, true},
+		{&Config{TTL: 60}, []Field{FieldTTL}, false},
+		{&Config{TTL: 60, Zone: "example.com"}, []Field{FieldTTL}, true},
+		{&Config{TTL: 60, Zone: "example.com"}, []Field{FieldTTL, FieldZone}, false},
+	}
+	for _, test := range tests {
+		if err := test.config.CheckFields(test.supported...); (err != nil) != test.err {
+			t.Errorf("Expected error %t for %+v supporting %v, got %v", test.err, test.config, test.supported, err)
+		}
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	p := &closingProvider{}
+	if provider := WithTimeout(p, &Config{TTL: 60}); provider != p {
+		t.Error("Expected the provider unchanged without propagation timeout")
+	}
+	provider := WithTimeout(p, &Config{Propagation: &Propagation{Timeout: time.Minute}})
+	timeout, interval := provider.(acme.ChallengeProviderTimeout).Timeout()
+	if timeout != time.Minute || interval != 2*time.Second {
+		t.Errorf("Expected the timeout of the config and the default interval, got %s and %s", timeout, interval)
+	}
+	if err := Close(provider); err != nil || !p.closed {
+		t.Errorf("Expected the wrapped provider to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package dnsprovider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+)
+
+const maxCNAMEHops = 10
+
+// nameserverPort is the port of the authoritative nameservers.
+var nameserverPort = "53"
+
+// legoPreCheckDNS is the propagation check used for the records presented without
+// propagation options, acme.PreCheckDNS at the time PreCheckDNS was installed.
+var legoPreCheckDNS = acme.PreCheckDNS
+
+// installPreCheckDNS installs PreCheckDNS once per process.
+var installPreCheckDNS sync.Once
+var preCheckDNSInstalled atomic.Bool
+
+// Propagation holds the options of the challenge record propagation check.
+// Zero values keep the defaults of the provider.
+type Propagation struct {
+	// Resolvers are the recursive resolvers used to check the records e.g. "8.8.8.8:53"
+	// (default acme.RecursiveNameservers).
+	Resolvers []string
+	// Authoritative checks the records against the authoritative nameservers of the zone
+	// rather than through the recursive resolvers.
+	Authoritative bool
+	// Timeout is the max time to wait for the records to propagate.
+	Timeout time.Duration
+	// PollingInterval is the interval between checks.
+	PollingInterval time.Duration
+	// Delay is a fixed delay after presenting the records, before the first check.
+	Delay time.Duration
+	// Skip disables the check, the records are considered propagated after Delay.
+	Skip bool
+}
+
+// Merge returns a copy of the propagation options overridden by the options of the config,
+// or nil if neither sets any option.
+func (p *Propagation) Merge(config *Config) *Propagation {
+	if p == nil && (config == nil || config.Propagation == nil) {
+		return nil
+	}
+	merged := Propagation{}
+	if p != nil {
+		merged = *p
+	}
+	if config != nil && config.Propagation != nil {
+		o := config.Propagation
+		if len(o.Resolvers) > 0 {
+			merged.Resolvers = o.Resolvers
+		}
+		merged.Authoritative = merged.Authoritative || o.Authoritative
+		if o.Timeout > 0 {
+			merged.Timeout = o.Timeout
+		}
+		if o.PollingInterval > 0 {
+			merged.PollingInterval = o.PollingInterval
+		}
+		if o.Delay > 0 {
+			merged.Delay = o.Delay
+		}
+		merged.Skip = merged.Skip || o.Skip
+	}
+	return &merged
+}
+
+// pending holds the propagation options of the records in flight. The records are keyed
+// by FQDN and value so that the configs sharing the process, and PreCheckDNS, do not
+// overwrite the options of each other's records.
+var pendingMutex sync.RWMutex
+var pending = make(map[string]*Propagation)
+
+func pendingKey(fqdn, value string) string {
+	return strings.ToLower(fqdn) + " " + value
+}
+
+// PreCheckDNS checks the propagation of the challenge record with the options of the
+// provider which presented it, and falls back to the previous acme.PreCheckDNS for
+// the other records.
+func PreCheckDNS(fqdn, value string) (bool, error) {
+	pendingMutex.RLock()
+	p, found := pending[pendingKey(fqdn, value)]
+	pendingMutex.RUnlock()
+	if !found {
+		return legoPreCheckDNS(fqdn, value)
+	}
+	return p.check(fqdn, value)
+}
+
+// InstallPreCheckDNS assigns PreCheckDNS to acme.PreCheckDNS, the lego global shared by
+// all the lego clients of the process, the first time it is called. It is never called
+// by this package nor by ACME.
+func InstallPreCheckDNS() {
+	installPreCheckDNS.Do(func() {
+		legoPreCheckDNS = acme.PreCheckDNS
+		acme.PreCheckDNS = PreCheckDNS
+		preCheckDNSInstalled.Store(true)
+	})
+}
+
+// Validate returns an error if the options are only applied by PreCheckDNS
+// and InstallPreCheckDNS was not called.
+func (p *Propagation) Validate() error {
+	if p == nil || (len(p.Resolvers) == 0 && !p.Authoritative && !p.Skip) || preCheckDNSInstalled.Load() {
+		return nil
+	}
+	return fmt.Errorf("Propagation Resolvers, Authoritative and Skip require dnsprovider.InstallPreCheckDNS")
+}
+
+// propagationProvider applies the propagation options to a provider
+// and implements the acme.ChallengeProviderTimeout interface.
+type propagationProvider struct {
+	acme.ChallengeProvider
+	ctx         context.Context
+	propagation *Propagation
+}
+
+// WithPropagation returns the provider checking the propagation of its records with the options.
+// The propagation delay is cut short when the context is done.
+func WithPropagation(ctx context.Context, provider acme.ChallengeProvider, propagation *Propagation) acme.ChallengeProvider {
+	if propagation == nil {
+		return provider
+	}
+	return &propagationProvider{ChallengeProvider: provider, ctx: ctx, propagation: propagation}
+}
+
+// Present presents the challenge record and waits for the propagation delay.
+func (p *propagationProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	pendingMutex.Lock()
+	pending[pendingKey(fqdn, value)] = p.propagation
+	pendingMutex.Unlock()
+	if err := p.ChallengeProvider.Present(domain, token, keyAuth); err != nil {
+		return err
+	}
+	if p.propagation.Delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(p.propagation.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-p.ctx.Done():
+		return fmt.Errorf("Propagation delay of %s interrupted: %v", fqdn, p.ctx.Err())
+	}
+}
+
+// CleanUp removes the challenge record.
+func (p *propagationProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	pendingMutex.Lock()
+	delete(pending, pendingKey(fqdn, value))
+	pendingMutex.Unlock()
+	return p.ChallengeProvider.CleanUp(domain, token, keyAuth)
+}
+
+// Unwrap returns the wrapped provider.
+func (p *propagationProvider) Unwrap() acme.ChallengeProvider {
+	return p.ChallengeProvider
+}
+
+// Timeout returns the propagation timeout and polling interval.
+func (p *propagationProvider) Timeout() (timeout, interval time.Duration) {
+	timeout, interval = 60*time.Second, 2*time.Second
+	if provider, ok := p.ChallengeProvider.(acme.ChallengeProviderTimeout); ok {
+		timeout, interval = provider.Timeout()
+	}
+	if p.propagation.Timeout > 0 {
+		timeout = p.propagation.Timeout
+	}
+	if p.propagation.PollingInterval > 0 {
+		interval = p.propagation.PollingInterval
+	}
+	return timeout, interval
+}
+
+// check returns true if the TXT record with the value is visible at the FQDN,
+// following the CNAME records.
+func (p *Propagation) check(fqdn, value string) (bool, error) {
+	if p.Skip {
+		return true, nil
+	}
+	resolvers := p.resolvers()
+	if p.Authoritative {
+		return CheckAuthoritative(fqdn, value, resolvers)
+	}
+	fqdn, err := FollowCNAME(fqdn, resolvers)
+	if err != nil {
+		return false, err
+	}
+	r, err := query(fqdn, dns.TypeTXT, resolvers, true)
+	if err != nil {
+		return false, err
+	}
+	return hasTXT(r, value), nil
+}
+
+// CheckAuthoritative returns true if the TXT record with the value is visible at the FQDN,
+// following the CNAME records, on all the authoritative nameservers of its zone. The CNAME
+// records, zone and nameservers are looked up through the resolvers (default acme.RecursiveNameservers).
+func CheckAuthoritative(fqdn, value string, resolvers []string) (bool, error) {
+	resolvers = ResolverAddresses(resolvers)
+	fqdn, err := FollowCNAME(fqdn, resolvers)
+	if err != nil {
+		return false, err
+	}
+	nameservers, err := authoritativeNameservers(fqdn, resolvers)
+	if err != nil {
+		return false, err
+	}
+	for _, ns := range nameservers {
+		r, err := query(fqdn, dns.TypeTXT, []string{ns}, false)
+		if err != nil {
+			return false, err
+		}
+		if !hasTXT(r, value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (p *Propagation) resolvers() []string {
+	return ResolverAddresses(p.Resolvers)
+}
+
+// ResolverAddresses returns the addresses of the resolvers with the default port,
+// or acme.RecursiveNameservers if there is none.
+func ResolverAddresses(resolvers []string) []string {
+	if len(resolvers) == 0 {
+		return acme.RecursiveNameservers
+	}
+	addresses := make([]string, len(resolvers))
+	for i, resolver := range resolvers {
+		if _, _, err := net.SplitHostPort(resolver); err != nil {
+			resolver = net.JoinHostPort(resolver, "53")
+		}
+		addresses[i] = resolver
+	}
+	return addresses
+}
+
+// authoritativeNameservers returns the addresses of the authoritative nameservers of the FQDN zone.
+func authoritativeNameservers(fqdn string, resolvers []string) ([]string, error) {
+	zone, err := acme.FindZoneByFqdn(fqdn, resolvers)
+	if err != nil {
+		return nil, err
+	}
+	r, err := query(zone, dns.TypeNS, resolvers, true)
+	if err != nil {
+		return nil, err
+	}
+	var nameservers []string
+	for _, rr := range r.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			nameservers = append(nameservers, net.JoinHostPort(strings.TrimSuffix(ns.Ns, "."), nameserverPort))
+		}
+	}
+	if len(nameservers) == 0 {
+		return nil, fmt.Errorf("Could not determine the authoritative nameservers of %s", zone)
+	}
+	return nameservers, nil
+}
+
+// query sends the question to the nameservers in turn until one answers,
+// retrying over TCP if the UDP answer is truncated.
+func query(fqdn string, rtype uint16, nameservers []string, recursive bool) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn, rtype)
+	m.RecursionDesired = recursive
+
+	var r *dns.Msg
+	var err error
+	for _, ns := range nameservers {
+		r, _, err = (&dns.Client{Timeout: acme.DNSTimeout}).Exchange(m, ns)
+		if err == nil && r.Truncated {
+			r, _, err = (&dns.Client{Net: "tcp", Timeout: acme.DNSTimeout}).Exchange(m, ns)
+		}
+		if err == nil {
+			return r, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no nameservers")
+	}
+	return nil, fmt.Errorf("Error querying %s: %v", fqdn, err)
+}
+
+// FollowCNAME returns the final target of the CNAME records of the FQDN, resolved
+// through the resolvers (default acme.RecursiveNameservers).
+func FollowCNAME(fqdn string, resolvers []string) (string, error) {
+	resolvers = ResolverAddresses(resolvers)
+	for i := 0; i < maxCNAMEHops; i++ {
+		r, err := query(fqdn, dns.TypeCNAME, resolvers, true)
+		if err != nil {
+			return "", err
+		}
+		target := cnameTarget(r, fqdn)
+		if target == "" {
+			return fqdn, nil
+		}
+		fqdn = target
+	}
+	return "", fmt.Errorf("Too many CNAME records following %s", fqdn)
+}
+
+func cnameTarget(r *dns.Msg, fqdn string) string {
+	for _, rr := range r.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, fqdn) {
+			return strings.ToLower(cname.Target)
+		}
+	}
+	return ""
+}
+
+func hasTXT(r *dns.Msg, value string) bool {
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok && strings.Join(txt.Txt, "") == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package dnsprovider
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+)
+
+type testProvider struct{}
+
+func (testProvider) Present(domain, token, keyAuth string) error { return nil }
+func (testProvider) CleanUp(domain, token, keyAuth string) error { return nil }
+
+func init() {
+	RegisterProvider("test", func(config *Config) (acme.ChallengeProvider, error) {
+		return testProvider{}, nil
+	})
+}
+
+// startResolver starts a DNS server on localhost answering the CNAME records
+// and returns its address.
+func startResolver(t *testing.T, cnames map[string]string) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		name := strings.ToLower(r.Question[0].Name)
+		if target, found := cnames[name]; found {
+			m.Answer = append(m.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+				Target: target,
+			})
+		}
+		w.WriteMsg(m)
+	})
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestFollowCNAME(t *testing.T) {
+	resolver := startResolver(t, map[string]string{
+		"_acme-challenge.example.com.": "_acme-challenge.Example.net.",
+		"_acme-challenge.example.net.": "challenges.example.org.",
+		"loop.example.com.":            "loop.example.com.",
+	})
+	tests := map[string]string{
+		"_acme-challenge.example.com.": "challenges.example.org.",
+		"_acme-challenge.example.org.": "_acme-challenge.example.org.",
+	}
+	for fqdn, expected := range tests {
+		target, err := FollowCNAME(fqdn, []string{resolver})
+		if err != nil || target != expected {
+			t.Errorf("Expected %s to resolve to %s, got %q, %v", fqdn, expected, target, err)
+		}
+	}
+	if _, err := FollowCNAME("loop.example.com.", []string{resolver}); err == nil || !strings.Contains(err.Error(), "Too many CNAME") {
+		t.Errorf("Expected too many CNAME records error, got %v", err)
+	}
+}
+
+func TestMergeTimeouts(t *testing.T) {
+	defaults := &Propagation{Timeout: 5 * time.Minute, PollingInterval: 10 * time.Second}
+	tests := []struct {
+		config   *Config
+		timeout  time.Duration
+		interval time.Duration
+	}{
+		{&Config{Propagation: &Propagation{}}, 5 * time.Minute, 10 * time.Second},
+		{&Config{Propagation: &Propagation{Timeout: time.Minute, PollingInterval: time.Second}}, time.Minute, time.Second},
+		{&Config{Propagation: &Propagation{Timeout: 2 * time.Minute}}, 2 * time.Minute, 10 * time.Second},
+	}
+	for i, test := range tests {
+		merged := defaults.Merge(test.config)
+		if merged.Timeout != test.timeout || merged.PollingInterval != test.interval {
+			t.Errorf("%d: expected timeout %s and interval %s, got %s and %s", i, test.timeout, test.interval, merged.Timeout, merged.PollingInterval)
+		}
+	}
+
+	config := &Config{Propagation: &Propagation{Timeout: time.Minute, PollingInterval: time.Second}}
+	provider, err := InitProvider("test", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider = WithPropagation(context.Background(), provider, defaults.Merge(config))
+	if timeout, interval := provider.(*propagationProvider).Timeout(); timeout != time.Minute || interval != time.Second {
+		t.Errorf("Expected the timeout and interval of the config, got %s and %s", timeout, interval)
+	}
+}
+
+func TestPropagationDelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	provider := WithPropagation(ctx, testProvider{}, &Propagation{Delay: time.Hour, Skip: true})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	err := provider.Present("example.com", "token", "key-auth")
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("Expected the delay to be interrupted, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("Expected the delay to stop with the context, waited %s", elapsed)
+	}
+}
+
+func TestPendingRecords(t *testing.T) {
+	preCheckDNS := legoPreCheckDNS
+	defer func() { legoPreCheckDNS = preCheckDNS }()
+	legoPreCheckDNS = func(fqdn, value string) (bool, error) {
+		return false, nil
+	}
+	// two configs presenting a record at the same FQDN.
+	first := WithPropagation(context.Background(), testProvider{}, &Propagation{Skip: true})
+	second := WithPropagation(context.Background(), testProvider{}, &Propagation{Skip: true})
+	fqdn, firstValue, _ := acme.DNS01Record("example.com", "first")
+	_, secondValue, _ := acme.DNS01Record("example.com", "second")
+
+	if err := first.Present("example.com", "token", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := PreCheckDNS(fqdn, firstValue); !ok {
+		t.Error("Expected the record checked with the options of its provider")
+	}
+	if ok, _ := PreCheckDNS(fqdn, secondValue); ok {
+		t.Error("Expected the record not presented with options to fall back to the previous check")
+	}
+	if err := second.Present("example.com", "token", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.CleanUp("example.com", "token", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := PreCheckDNS(fqdn, secondValue); !ok {
+		t.Error("Expected the record of the other config kept on clean up")
+	}
+	if err := second.CleanUp("example.com", "token", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := PreCheckDNS(fqdn, secondValue); ok {
+		t.Error("Expected the record removed on clean up")
+	}
+}
+
+// startAuthoritative starts a DNS server on localhost, authoritative for the zone with
+// the nameserver localhost, answering the TXT records, and returns its port.
+func startAuthoritative(t *testing.T, zone string, txt map[string]string) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 60}
+		switch {
+		case q.Qtype == dns.TypeSOA && q.Name == zone:
+			m.Answer = append(m.Answer, &dns.SOA{Hdr: hdr, Ns: "localhost.", Mbox: "hostmaster." + zone, Serial: 1})
+		case q.Qtype == dns.TypeNS && q.Name == zone:
+			m.Answer = append(m.Answer, &dns.NS{Hdr: hdr, Ns: "localhost."})
+		case q.Qtype == dns.TypeTXT && txt[q.Name] != "":
+			m.Answer = append(m.Answer, &dns.TXT{Hdr: hdr, Txt: []string{txt[q.Name]}})
+		}
+		w.WriteMsg(m)
+	})
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	_, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	return port
+}
+
+func TestCheckAuthoritative(t *testing.T) {
+	port := startAuthoritative(t, "example.test.", map[string]string{"_acme-challenge.www.example.test.": "served"})
+	nameserverPort = port
+	defer func() { nameserverPort = "53" }()
+	resolvers := []string{"127.0.0.1:" + port}
+
+	if ok, err := CheckAuthoritative("_acme-challenge.www.example.test.", "served", resolvers); !ok || err != nil {
+		t.Errorf("Expected the record served by the authoritative nameserver, got %t, %v", ok, err)
+	}
+	if ok, err := CheckAuthoritative("_acme-challenge.www.example.test.", "other", resolvers); ok || err != nil {
+		t.Errorf("Expected another value not to be served, got %t, %v", ok, err)
+	}
+	if ok, err := CheckAuthoritative("_acme-challenge.api.example.test.", "served", resolvers); ok || err != nil {
+		t.Errorf("Expected a record of another name not to be served, got %t, %v", ok, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, propagation := range []*Propagation{nil, {Timeout: time.Minute, Delay: time.Second}} {
+		if err := propagation.Validate(); err != nil {
+			t.Errorf("Expected %+v to be applied without PreCheckDNS, got %v", propagation, err)
+		}
+	}
+	options := []*Propagation{{Resolvers: []string{"8.8.8.8:53"}}, {Authoritative: true}, {Skip: true}}
+	for _, propagation := range options {
+		if err := propagation.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v without PreCheckDNS", propagation)
+		}
+	}
+	InstallPreCheckDNS()
+	for _, propagation := range options {
+		if err := propagation.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid with PreCheckDNS, got %v", propagation, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package acmedns
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName   = "acme-dns"
+	apiBaseEnv     = "ACME_DNS_API_BASE"
+	storagePathEnv = "ACME_DNS_STORAGE_PATH"
+	accountsEnv    = "ACME_DNS_ACCOUNTS"
+	requestTimeout = 30 * time.Second
+)
+
+// account is an acme-dns account, stored in the same format as the acme-dns client.
+type account struct {
+	FullDomain string `json:"fulldomain"`
+	SubDomain  string `json:"subdomain"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+}
+
+// CNAMERequiredError is returned when an account was registered for the domain
+// and the CNAME record delegating the challenge to acme-dns needs to be created.
+type CNAMERequiredError struct {
+	Domain string
+	FQDN   string
+	Target string
+}
+
+// Error implements the error interface.
+func (e *CNAMERequiredError) Error() string {
+	return fmt.Sprintf("acme-dns account registered for %s, create a CNAME record %s pointing to %s and retry",
+		e.Domain, e.FQDN, e.Target)
+}
+
+// dnsProvider updates the challenge records with the acme-dns HTTP API
+// and implements the acme.ChallengeProvider and dnsprovider.RecordProvider interfaces.
+type dnsProvider struct {
+	sync.Mutex
+	apiBase     string
+	storagePath string
+	// accounts holds the accounts of the config and of the storage file by domain.
+	accounts map[string]*account
+	// stored holds the accounts of the storage file by domain.
+	stored map[string]*account
+	client *http.Client
+}
+
+// Present updates the TXT record of the acme-dns account of the domain,
+// registering a new account if there is none.
+func (d *dnsProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	d.Lock()
+	acct, found := d.accounts[domain]
+	d.Unlock()
+	if !found {
+		acct, err := d.register(domain)
+		if err != nil {
+			return err
+		}
+		return &CNAMERequiredError{Domain: domain, FQDN: fqdn, Target: acct.FullDomain}
+	}
+	return d.update(acct, value)
+}
+
+// CleanUp is a no-op as acme-dns only keeps the latest records.
+func (d *dnsProvider) CleanUp(domain, token, keyAuth string) error {
+	return nil
+}
+
+// PresentRecord updates the TXT record of the acme-dns account of the FQDN.
+func (d *dnsProvider) PresentRecord(fqdn, value string) error {
+	fullDomain := strings.ToLower(acme.UnFqdn(fqdn))
+	var found *account
+	d.Lock()
+	for _, acct := range d.accounts {
+		if strings.ToLower(acct.FullDomain) == fullDomain {
+			found = acct
+			break
+		}
+	}
+	d.Unlock()
+	if found == nil {
+		return fmt.Errorf("No acme-dns account found for %s", fqdn)
+	}
+	return d.update(found, value)
+}
+
+// CleanUpRecord is a no-op as acme-dns only keeps the latest records.
+func (d *dnsProvider) CleanUpRecord(fqdn, value string) error {
+	return nil
+}
+
+func (d *dnsProvider) update(acct *account, value string) error {
+	body, err := json.Marshal(map[string]string{"subdomain": acct.SubDomain, "txt": value})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.apiBase+"/update", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-User", acct.Username)
+	req.Header.Set("X-Api-Key", acct.Password)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("acme-dns update of %s failed with status %d: %s", acct.FullDomain, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (d *dnsProvider) register(domain string) (*account, error) {
+	if d.storagePath == "" {
+		return nil, fmt.Errorf("No acme-dns account for %s and no storage path to register one", domain)
+	}
+	resp, err := d.client.Post(d.apiBase+"/register", "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("acme-dns registration for %s failed with status %d", domain, resp.StatusCode)
+	}
+	acct := &account{}
+	if err := json.NewDecoder(resp.Body).Decode(acct); err != nil {
+		return nil, fmt.Errorf("Error decoding acme-dns account: %v", err)
+	}
+
+	// the file is written under the lock so that concurrent registrations are all kept.
+	d.Lock()
+	defer d.Unlock()
+	d.accounts[domain] = acct
+	d.stored[domain] = acct
+	data, err := json.MarshalIndent(d.stored, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(d.storagePath, data, 0600); err != nil {
+		return nil, err
+	}
+	return acct, nil
+}
+
+func newDNSProvider(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+	if err := config.CheckFields(); err != nil {
+		return nil, err
+	}
+	apiBase := strings.TrimSuffix(config.Get("api_base", apiBaseEnv), "/")
+	if apiBase == "" {
+		return nil, errors.New("acme-dns API base url missing")
+	}
+	provider := &dnsProvider{
+		apiBase:     apiBase,
+		storagePath: config.Get("storage_path", storagePathEnv),
+		accounts:    make(map[string]*account),
+		stored:      make(map[string]*account),
+		client:      &http.Client{Timeout: requestTimeout},
+	}
+	if provider.storagePath != "" {
+		data, err := ioutil.ReadFile(provider.storagePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &provider.stored); err != nil {
+				return nil, fmt.Errorf("Error loading acme-dns accounts: %v", err)
+			}
+		}
+	}
+	for domain, acct := range provider.stored {
+		provider.accounts[domain] = acct
+	}
+	// the accounts of the config take precedence over the stored ones.
+	if accounts := config.Get("accounts", accountsEnv); accounts != "" {
+		configured := make(map[string]*account)
+		if err := json.Unmarshal([]byte(accounts), &configured); err != nil {
+			return nil, fmt.Errorf("Invalid acme-dns accounts: %v", err)
+		}
+		for domain, acct := range configured {
+			provider.accounts[domain] = acct
+		}
+	}
+	return provider, nil
+}
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		return newDNSProvider(config)
+	})
+}
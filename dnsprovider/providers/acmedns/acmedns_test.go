@@ -0,0 +1,184 @@
+package acmedns
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+// testUpdate is an update request received by the stand-in acme-dns server.
+type testUpdate struct {
+	User      string
+	Key       string
+	SubDomain string `json:"subdomain"`
+	TXT       string `json:"txt"`
+}
+
+// testACMEDNS is a stand-in acme-dns server registering one account and recording the updates.
+type testACMEDNS struct {
+	mu      sync.Mutex
+	updates []testUpdate
+	// block, if set, holds the updates until it is closed, after notifying arrived.
+	block   chan struct{}
+	arrived chan struct{}
+}
+
+func (s *testACMEDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/register":
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&account{
+			FullDomain: "d420c923-bbd7-4056-ab64-c3ca54c9b3cf.auth.example.org",
+			SubDomain:  "d420c923-bbd7-4056-ab64-c3ca54c9b3cf",
+			Username:   "registered",
+			Password:   "password",
+		})
+	case "/update":
+		update := testUpdate{User: r.Header.Get("X-Api-User"), Key: r.Header.Get("X-Api-Key")}
+		json.NewDecoder(r.Body).Decode(&update)
+		if s.block != nil {
+			s.arrived <- struct{}{}
+			<-s.block
+		}
+		s.mu.Lock()
+		s.updates = append(s.updates, update)
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"txt": update.TXT})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *testACMEDNS) received() []testUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updates
+}
+
+// testAccounts are the accounts of the config.
+const testAccounts = `{
+	"www.example.com": {"fulldomain": "www.auth.example.org", "subdomain": "www", "username": "user", "password": "secret"},
+	"api.example.com": {"fulldomain": "api.auth.example.org", "subdomain": "api", "username": "api-user", "password": "api-secret"}
+}`
+
+func newTestProvider(t *testing.T, s *testACMEDNS, credentials map[string]string) *dnsProvider {
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	config := &dnsprovider.Config{Credentials: map[string]string{"api_base": server.URL + "/"}}
+	for key, value := range credentials {
+		config.Credentials[key] = value
+	}
+	provider, err := newDNSProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.(*dnsProvider)
+}
+
+func TestPresentWithConfiguredAccounts(t *testing.T) {
+	s := &testACMEDNS{}
+	d := newTestProvider(t, s, map[string]string{"accounts": testAccounts})
+	if err := d.Present("www.example.com", "token", "key-auth"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PresentRecord("api.auth.example.org.", "value"); err != nil {
+		t.Fatal(err)
+	}
+	updates := s.received()
+	if len(updates) != 2 {
+		t.Fatalf("Expected 2 updates, got %+v", updates)
+	}
+	if u := updates[0]; u.User != "user" || u.Key != "secret" || u.SubDomain != "www" || u.TXT == "" {
+		t.Errorf("Expected the update of the www account, got %+v", u)
+	}
+	if u := updates[1]; u != (testUpdate{User: "api-user", Key: "api-secret", SubDomain: "api", TXT: "value"}) {
+		t.Errorf("Expected the update of the account of the FQDN, got %+v", u)
+	}
+	if err := d.PresentRecord("unknown.auth.example.org.", "value"); err == nil {
+		t.Error("Expected an error without account for the FQDN")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	s := &testACMEDNS{}
+	storagePath := filepath.Join(t.TempDir(), "accounts.json")
+	d := newTestProvider(t, s, map[string]string{"storage_path": storagePath, "accounts": testAccounts})
+
+	var cerr *CNAMERequiredError
+	err := d.Present("new.example.com", "token", "key-auth")
+	if !errors.As(err, &cerr) || cerr.FQDN != "_acme-challenge.new.example.com." ||
+		cerr.Target != "d420c923-bbd7-4056-ab64-c3ca54c9b3cf.auth.example.org" {
+		t.Fatalf("Expected a CNAME required error, got %v", err)
+	}
+	data, err := ioutil.ReadFile(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make(map[string]*account)
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	// the accounts of the config are not written to the storage file.
+	if len(stored) != 1 || stored["new.example.com"] == nil || stored["new.example.com"].Username != "registered" {
+		t.Errorf("Expected only the registered account stored, got %s", data)
+	}
+
+	// the account is used once the CNAME record is created.
+	reloaded := newTestProvider(t, s, map[string]string{"storage_path": storagePath})
+	if err := reloaded.Present("new.example.com", "token", "key-auth"); err != nil {
+		t.Fatal(err)
+	}
+	if updates := s.received(); len(updates) != 1 || updates[0].User != "registered" {
+		t.Errorf("Expected the update of the registered account, got %+v", updates)
+	}
+}
+
+func TestRegisterWithoutStorage(t *testing.T) {
+	d := newTestProvider(t, &testACMEDNS{}, nil)
+	if err := d.Present("www.example.com", "token", "key-auth"); err == nil {
+		t.Error("Expected an error without account nor storage path")
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	s := &testACMEDNS{block: make(chan struct{}), arrived: make(chan struct{}, 2)}
+	d := newTestProvider(t, s, map[string]string{"accounts": testAccounts})
+	errs := make(chan error, 2)
+	go func() { errs <- d.PresentRecord("www.auth.example.org.", "www") }()
+	go func() { errs <- d.PresentRecord("api.auth.example.org.", "api") }()
+	// the provider must not be locked while an update is in flight.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-s.arrived:
+		case <-time.After(5 * time.Second):
+			close(s.block)
+			t.Fatal("Expected both updates in flight at once")
+		}
+	}
+	close(s.block)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if updates := s.received(); len(updates) != 2 {
+		t.Errorf("Expected both updates, got %+v", updates)
+	}
+}
+
+func TestInvalidAccounts(t *testing.T) {
+	if _, err := newDNSProvider(&dnsprovider.Config{Credentials: map[string]string{
+		"api_base": "http://localhost",
+		"accounts": "{",
+	}}); err == nil {
+		t.Error("Expected an error with invalid accounts")
+	}
+}
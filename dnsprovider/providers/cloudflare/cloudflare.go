@@ -0,0 +1,24 @@
+package cloudflare
+
+import (
+	"github.com/xenolf/lego/acme"
+	lego "github.com/xenolf/lego/providers/dns/cloudflare"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName = "cloudflare"
+)
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		if err := config.CheckFields(); err != nil {
+			return nil, err
+		}
+		return lego.NewDNSProviderCredentials(
+			config.Get("email", "CLOUDFLARE_EMAIL"),
+			config.Get("api_key", "CLOUDFLARE_API_KEY"),
+		)
+	})
+}
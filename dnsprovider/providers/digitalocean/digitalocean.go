@@ -0,0 +1,21 @@
+package digitalocean
+
+import (
+	"github.com/xenolf/lego/acme"
+	lego "github.com/xenolf/lego/providers/dns/digitalocean"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName = "digitalocean"
+)
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		if err := config.CheckFields(); err != nil {
+			return nil, err
+		}
+		return lego.NewDNSProviderCredentials(config.Get("auth_token", "DO_AUTH_TOKEN"))
+	})
+}
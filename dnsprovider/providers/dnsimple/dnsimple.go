@@ -0,0 +1,24 @@
+package dnsimple
+
+import (
+	"github.com/xenolf/lego/acme"
+	lego "github.com/xenolf/lego/providers/dns/dnsimple"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName = "dnsimple"
+)
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		if err := config.CheckFields(); err != nil {
+			return nil, err
+		}
+		return lego.NewDNSProviderCredentials(
+			config.Get("email", "DNSIMPLE_EMAIL"),
+			config.Get("api_key", "DNSIMPLE_API_KEY"),
+		)
+	})
+}
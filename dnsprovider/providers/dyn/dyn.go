@@ -0,0 +1,25 @@
+package dyn
+
+import (
+	"github.com/xenolf/lego/acme"
+	lego "github.com/xenolf/lego/providers/dns/dyn"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName = "dyn"
+)
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		if err := config.CheckFields(); err != nil {
+			return nil, err
+		}
+		return lego.NewDNSProviderCredentials(
+			config.Get("customer_name", "DYN_CUSTOMER_NAME"),
+			config.Get("user_name", "DYN_USER_NAME"),
+			config.Get("password", "DYN_PASSWORD"),
+		)
+	})
+}
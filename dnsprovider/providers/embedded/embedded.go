@@ -0,0 +1,259 @@
+package embedded
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jtblin/go-logger"
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName   = "embedded"
+	addressEnv     = "EMBEDDED_DNS_ADDRESS"
+	zoneEnv        = "EMBEDDED_DNS_ZONE"
+	nameserverEnv  = "EMBEDDED_DNS_NAMESERVER"
+	defaultAddress = ":53"
+	defaultTTL     = 60
+)
+
+// server is an authoritative DNS server answering the TXT records of the challenges
+// in flight and implements the acme.ChallengeProvider and dnsprovider.RecordProvider interfaces.
+//
+// Without zone, the records are served at their challenge FQDN e.g. when the
+// _acme-challenge names are delegated to the server with NS records. With a zone,
+// the records are served at the domain name within the zone e.g. for foo.my-domain.io
+// and zone acme.my-domain.net, _acme-challenge.foo.my-domain.io is delegated with
+// a CNAME to foo.my-domain.io.acme.my-domain.net.
+type server struct {
+	sync.RWMutex
+	records    map[string][]string
+	zone       string
+	nameserver string
+	ttl        uint32
+	servers    []*dns.Server
+	logger     logger.Interface
+}
+
+// Present serves the TXT record for the challenge.
+func (s *server) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return s.PresentRecord(s.name(domain, fqdn), value)
+}
+
+// CleanUp removes the TXT record for the challenge.
+func (s *server) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return s.CleanUpRecord(s.name(domain, fqdn), value)
+}
+
+// PresentRecord serves the TXT record at the FQDN.
+func (s *server) PresentRecord(fqdn, value string) error {
+	name := strings.ToLower(fqdn)
+	s.Lock()
+	defer s.Unlock()
+	s.records[name] = append(s.records[name], value)
+	return nil
+}
+
+// CleanUpRecord removes the TXT record at the FQDN.
+func (s *server) CleanUpRecord(fqdn, value string) error {
+	name := strings.ToLower(fqdn)
+	s.Lock()
+	defer s.Unlock()
+	// a new slice is built as the values may be answered concurrently.
+	var values []string
+	for _, v := range s.records[name] {
+		if v != value {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		delete(s.records, name)
+	} else {
+		s.records[name] = values
+	}
+	return nil
+}
+
+// name returns the name of the record served for the challenge.
+func (s *server) name(domain, fqdn string) string {
+	if s.zone == "" {
+		return strings.ToLower(fqdn)
+	}
+	return strings.ToLower(dns.Fqdn(strings.TrimPrefix(domain, "*.")) + s.zone)
+}
+
+// ServeDNS answers the queries for the records in flight.
+func (s *server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	if len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return
+	}
+
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+	if s.zone != "" && !dns.IsSubDomain(s.zone, name) {
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return
+	}
+
+	s.RLock()
+	values, found := s.records[name]
+	s.RUnlock()
+	switch {
+	case s.zone != "" && name == s.zone:
+		switch q.Qtype {
+		case dns.TypeSOA, dns.TypeANY:
+			m.Answer = append(m.Answer, s.soa())
+		case dns.TypeNS:
+			m.Answer = append(m.Answer, &dns.NS{Hdr: s.header(dns.TypeNS), Ns: s.nameserver})
+		default:
+			m.Ns = append(m.Ns, s.soa())
+		}
+	case found:
+		if q.Qtype == dns.TypeTXT || q.Qtype == dns.TypeANY {
+			for _, value := range values {
+				m.Answer = append(m.Answer, &dns.TXT{
+					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: s.ttl},
+					Txt: []string{value},
+				})
+			}
+		}
+	case s.zone == "":
+		m.SetRcode(r, dns.RcodeRefused)
+	default:
+		m.SetRcode(r, dns.RcodeNameError)
+		m.Ns = append(m.Ns, s.soa())
+	}
+	w.WriteMsg(m)
+}
+
+func (s *server) header(rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{Name: s.zone, Rrtype: rrtype, Class: dns.ClassINET, Ttl: s.ttl}
+}
+
+func (s *server) soa() dns.RR {
+	return &dns.SOA{
+		Hdr:     s.header(dns.TypeSOA),
+		Ns:      s.nameserver,
+		Mbox:    "hostmaster." + s.zone,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  s.ttl,
+	}
+}
+
+// listen starts the UDP and TCP servers on the address.
+func (s *server) listen(address string) error {
+	pc, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	s.servers = []*dns.Server{
+		{PacketConn: pc, Handler: s},
+		{Listener: l, Handler: s},
+	}
+	// the servers are waited for so that they can be shut down once listen returns.
+	started := make(chan error, len(s.servers))
+	for _, srv := range s.servers {
+		srv.NotifyStartedFunc = func() { notify(started, nil) }
+		go func(srv *dns.Server) {
+			if err := srv.ActivateAndServe(); err != nil {
+				s.logger.Printf("Error serving DNS challenges on %s: %v\n", address, err)
+				notify(started, err)
+			}
+		}(srv)
+	}
+	for range s.servers {
+		if err = <-started; err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// notify sends the outcome of a server start unless nobody waits for it anymore.
+func notify(started chan<- error, err error) {
+	select {
+	case started <- err:
+	default:
+	}
+}
+
+// Close stops the UDP and TCP servers.
+func (s *server) Close() error {
+	s.Lock()
+	servers := s.servers
+	s.servers = nil
+	s.Unlock()
+	var errs []string
+	for _, srv := range servers {
+		if err := srv.Shutdown(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Error stopping the embedded DNS server: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func newDNSProvider(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+	address := config.Get("address", addressEnv)
+	if address == "" {
+		address = defaultAddress
+	}
+	s := &server{
+		records: make(map[string][]string),
+		ttl:     defaultTTL,
+		logger:  log.New(os.Stdout, "[go-acme] ", log.Ldate|log.Ltime|log.Lshortfile),
+	}
+	if config != nil && config.Logger != nil {
+		s.logger = config.Logger
+	}
+	zone := config.Get("zone", zoneEnv)
+	if config != nil && config.Zone != "" {
+		zone = config.Zone
+	}
+	if zone != "" {
+		s.zone = strings.ToLower(dns.Fqdn(zone))
+		s.nameserver = dns.Fqdn(config.Get("nameserver", nameserverEnv))
+		if s.nameserver == "." {
+			s.nameserver = "ns." + s.zone
+		}
+	}
+	if config != nil && config.TTL > 0 {
+		s.ttl = uint32(config.TTL)
+	}
+	if err := s.listen(address); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		return newDNSProvider(config)
+	})
+}
@@ -0,0 +1,159 @@
+package embedded
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+func newTestServer(t *testing.T, credentials map[string]string) *server {
+	config := &dnsprovider.Config{Credentials: map[string]string{"address": "127.0.0.1:0"}}
+	for key, value := range credentials {
+		config.Credentials[key] = value
+	}
+	provider, err := newDNSProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := provider.(*server)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// query sends the TXT query for the name over UDP and returns the values answered.
+func query(t *testing.T, s *server, name string) (int, []string) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeTXT)
+	r, err := dns.Exchange(m, s.servers[0].PacketConn.LocalAddr().String())
+	if err != nil {
+		t.Error(err)
+		return dns.RcodeServerFailure, nil
+	}
+	var values []string
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			values = append(values, txt.Txt...)
+		}
+	}
+	return r.Rcode, values
+}
+
+func TestPresentAndCleanUpRecord(t *testing.T) {
+	s := newTestServer(t, nil)
+	fqdn := "_acme-challenge.Example.com."
+	if err := s.PresentRecord(fqdn, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PresentRecord(fqdn, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, values := query(t, s, fqdn); len(values) != 2 || values[0] != "first" || values[1] != "second" {
+		t.Errorf("Expected both records, got %v", values)
+	}
+	if err := s.CleanUpRecord(fqdn, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, values := query(t, s, fqdn); len(values) != 1 || values[0] != "second" {
+		t.Errorf("Expected the second record, got %v", values)
+	}
+	s.CleanUpRecord(fqdn, "second")
+	if rcode, values := query(t, s, fqdn); rcode != dns.RcodeRefused || len(values) != 0 {
+		t.Errorf("Expected refused without zone, got %s %v", dns.RcodeToString[rcode], values)
+	}
+}
+
+func TestZone(t *testing.T) {
+	s := newTestServer(t, map[string]string{"zone": "acme.example.net"})
+	if err := s.Present("foo.example.com", "token", "key-auth"); err != nil {
+		t.Fatal(err)
+	}
+	if _, values := query(t, s, "foo.example.com.acme.example.net."); len(values) != 1 {
+		t.Errorf("Expected the record within the zone, got %v", values)
+	}
+	if rcode, _ := query(t, s, "bar.example.com.acme.example.net."); rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN, got %s", dns.RcodeToString[rcode])
+	}
+	if rcode, _ := query(t, s, "foo.example.com."); rcode != dns.RcodeRefused {
+		t.Errorf("Expected refused out of the zone, got %s", dns.RcodeToString[rcode])
+	}
+}
+
+func TestConcurrentCleanUp(t *testing.T) {
+	s := newTestServer(t, nil)
+	fqdn := "_acme-challenge.example.com."
+	// the records are answered while others at the same name are cleaned up.
+	s.PresentRecord(fqdn, "stable")
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				value := fmt.Sprintf("value-%d-%d", i, j)
+				s.PresentRecord(fqdn, value)
+				s.CleanUpRecord(fqdn, value)
+			}
+		}(i)
+	}
+	for j := 0; j < 20; j++ {
+		if _, values := query(t, s, fqdn); len(values) == 0 || values[0] != "stable" {
+			t.Errorf("Expected the stable record first, got %v", values)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestCloseReleasesAddress(t *testing.T) {
+	s := newTestServer(t, nil)
+	address := s.servers[0].PacketConn.LocalAddr().String()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Expected close to succeed, got %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Expected close to be idempotent, got %v", err)
+	}
+
+	// the UDP and TCP ports are both free after close.
+	provider, err := newDNSProvider(&dnsprovider.Config{Credentials: map[string]string{"address": address}})
+	if err != nil {
+		t.Fatalf("Expected the address to be released, got %v", err)
+	}
+	reopened := provider.(*server)
+	defer reopened.Close()
+	reopened.PresentRecord("_acme-challenge.example.com.", "value")
+	if _, values := query(t, reopened, "_acme-challenge.example.com."); len(values) != 1 {
+		t.Errorf("Expected the reopened server to answer, got %v", values)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	provider, err := newDNSProvider(&dnsprovider.Config{
+		Credentials: map[string]string{"address": "127.0.0.1:0"},
+		Logger:      logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := provider.(*server)
+	defer s.Close()
+	if s.logger != logger {
+		t.Error("Expected the logger of the config")
+	}
+	if s := newTestServer(t, nil); s.logger == nil {
+		t.Error("Expected a default logger")
+	}
+}
@@ -0,0 +1,129 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName   = "exec"
+	commandEnv     = "EXEC_PATH"
+	modeEnv        = "EXEC_MODE"
+	timeoutEnv     = "EXEC_TIMEOUT"
+	modeArgs       = "args"
+	modeEnvOnly    = "env"
+	defaultTimeout = 60 * time.Second
+	// waitDelay bounds the wait for the output of the program after it is killed, e.g. when
+	// children it started still hold stderr open.
+	waitDelay = time.Second
+)
+
+// dnsProvider runs a user program to present and clean up the challenge records
+// and implements the acme.ChallengeProvider interface.
+//
+// The program is called with the action (present or cleanup), FQDN, token and key
+// authorization as arguments, or only with the action in env mode. The values are
+// always set in the ACME_ACTION, ACME_DOMAIN, ACME_FQDN, ACME_TOKEN, ACME_KEY_AUTH,
+// ACME_VALUE and ACME_TTL environment variables.
+type dnsProvider struct {
+	command string
+	mode    string
+	timeout time.Duration
+	ttl     int
+}
+
+// Present runs the program to create the challenge record.
+func (d *dnsProvider) Present(domain, token, keyAuth string) error {
+	return d.run("present", domain, token, keyAuth)
+}
+
+// CleanUp runs the program to remove the challenge record.
+func (d *dnsProvider) CleanUp(domain, token, keyAuth string) error {
+	return d.run("cleanup", domain, token, keyAuth)
+}
+
+func (d *dnsProvider) run(action, domain, token, keyAuth string) error {
+	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
+	if d.ttl > 0 {
+		ttl = d.ttl
+	}
+	args := []string{action}
+	if d.mode == modeArgs {
+		args = append(args, fqdn, token, keyAuth)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, d.command, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+	cmd.Env = append(os.Environ(),
+		"ACME_ACTION="+action,
+		"ACME_DOMAIN="+domain,
+		"ACME_FQDN="+fqdn,
+		"ACME_TOKEN="+token,
+		"ACME_KEY_AUTH="+keyAuth,
+		"ACME_VALUE="+value,
+		"ACME_TTL="+strconv.Itoa(ttl),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", d.timeout)
+		}
+		return fmt.Errorf("%s %s for %s failed: %v: %s", d.command, action, fqdn, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func newDNSProvider(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+	if err := config.CheckFields(dnsprovider.FieldTTL); err != nil {
+		return nil, err
+	}
+	command := config.Get("command", commandEnv)
+	if command == "" {
+		return nil, errors.New("Exec DNS provider command missing")
+	}
+	mode := config.Get("mode", modeEnv)
+	switch mode {
+	case "":
+		mode = modeArgs
+	case modeArgs, modeEnvOnly:
+	default:
+		return nil, fmt.Errorf("Unknown exec DNS provider mode %q", mode)
+	}
+	timeout := defaultTimeout
+	if value := config.Get("timeout", timeoutEnv); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("Invalid exec DNS provider timeout %q: %v", value, err)
+		}
+	}
+	provider := &dnsProvider{
+		command: command,
+		mode:    mode,
+		timeout: timeout,
+	}
+	if config != nil {
+		provider.ttl = config.TTL
+	}
+	return provider, nil
+}
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		return newDNSProvider(config)
+	})
+}
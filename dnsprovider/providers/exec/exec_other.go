@@ -0,0 +1,11 @@
+//go:build !unix
+
+package exec
+
+import (
+	"os/exec"
+)
+
+// killProcessGroup does nothing on platforms without process groups, the command is
+// killed on timeout and WaitDelay bounds the wait for its children.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,118 @@
+package exec
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+// writeScript writes a shell script recording its arguments and environment to a records file.
+func writeScript(t *testing.T, body string) (string, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Shell scripts are not supported on windows")
+	}
+	dir := t.TempDir()
+	records := filepath.Join(dir, "records")
+	script := filepath.Join(dir, "dns.sh")
+	content := "#!/bin/sh\nRECORDS=" + records + "\n" + body
+	if err := ioutil.WriteFile(script, []byte(content), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return script, records
+}
+
+func readRecords(t *testing.T, records string) []string {
+	data, err := ioutil.ReadFile(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestPresentAndCleanUpWithArgs(t *testing.T) {
+	script, records := writeScript(t, `echo "$1 $2 $3 $4 $ACME_VALUE $ACME_TTL" >> $RECORDS`)
+	provider, err := newDNSProvider(&dnsprovider.Config{Credentials: map[string]string{"command": script}, TTL: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.Present("example.com", "token", "key-auth"); err != nil {
+		t.Fatalf("Expected present to succeed, got %v", err)
+	}
+	if err := provider.CleanUp("example.com", "token", "key-auth"); err != nil {
+		t.Fatalf("Expected cleanup to succeed, got %v", err)
+	}
+	lines := readRecords(t, records)
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 calls, got %v", lines)
+	}
+	for i, action := range []string{"present", "cleanup"} {
+		fields := strings.Fields(lines[i])
+		if len(fields) != 6 || fields[0] != action || fields[1] != "_acme-challenge.example.com." ||
+			fields[2] != "token" || fields[3] != "key-auth" || fields[4] == "" || fields[5] != "60" {
+			t.Errorf("Unexpected %s call %q", action, lines[i])
+		}
+	}
+}
+
+func TestPresentWithEnv(t *testing.T) {
+	script, records := writeScript(t, `echo "$# $1 $ACME_ACTION $ACME_DOMAIN $ACME_FQDN $ACME_TOKEN $ACME_KEY_AUTH" >> $RECORDS`)
+	provider, err := newDNSProvider(&dnsprovider.Config{Credentials: map[string]string{"command": script, "mode": "env"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.Present("example.com", "token", "key-auth"); err != nil {
+		t.Fatalf("Expected present to succeed, got %v", err)
+	}
+	expected := "1 present present example.com _acme-challenge.example.com. token key-auth"
+	if lines := readRecords(t, records); len(lines) != 1 || lines[0] != expected {
+		t.Errorf("Expected %q, got %v", expected, lines)
+	}
+}
+
+func TestStderrInError(t *testing.T) {
+	script, _ := writeScript(t, `echo "zone not found" >&2; exit 3`)
+	provider, err := newDNSProvider(&dnsprovider.Config{Credentials: map[string]string{"command": script}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Present("example.com", "token", "key-auth")
+	if err == nil || !strings.Contains(err.Error(), "zone not found") || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Expected the exit status and stderr in the error, got %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// the child of the shell holds stderr open.
+	script, _ := writeScript(t, `sleep 5`)
+	provider, err := newDNSProvider(&dnsprovider.Config{Credentials: map[string]string{"command": script, "timeout": "500ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = provider.Present("example.com", "token", "key-auth")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 500ms") {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the script to be killed after the timeout, returned after %s", elapsed)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := map[string]map[string]string{
+		"missing command": {},
+		"unknown mode":    {"command": "/bin/true", "mode": "stdin"},
+		"invalid timeout": {"command": "/bin/true", "timeout": "soon"},
+	}
+	for name, credentials := range tests {
+		t.Setenv(commandEnv, "")
+		if _, err := newDNSProvider(&dnsprovider.Config{Credentials: credentials}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
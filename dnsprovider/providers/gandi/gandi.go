@@ -0,0 +1,21 @@
+package gandi
+
+import (
+	"github.com/xenolf/lego/acme"
+	lego "github.com/xenolf/lego/providers/dns/gandi"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName = "gandi"
+)
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		if err := config.CheckFields(); err != nil {
+			return nil, err
+		}
+		return lego.NewDNSProviderCredentials(config.Get("api_key", "GANDI_API_KEY"))
+	})
+}
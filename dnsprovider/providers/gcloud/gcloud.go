@@ -0,0 +1,181 @@
+package gcloud
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/dns/v1"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName    = "gcloud"
+	defaultTTL      = 120
+	defaultTimeout  = 180 * time.Second
+	defaultInterval = 5 * time.Second
+)
+
+// dnsProvider is a Google Cloud DNS provider configured programmatically
+// and implements the acme.ChallengeProviderTimeout and dnsprovider.RecordProvider interfaces.
+type dnsProvider struct {
+	client  *dns.Service
+	project string
+	ttl     int
+	// zone is the name of the managed zone, looked up by domain if empty.
+	zone string
+}
+
+// Present creates the TXT record for the challenge.
+func (d *dnsProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.PresentRecord(fqdn, value)
+}
+
+// CleanUp removes the TXT record for the challenge.
+func (d *dnsProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.CleanUpRecord(fqdn, value)
+}
+
+// PresentRecord adds the value to the TXT record at the FQDN.
+func (d *dnsProvider) PresentRecord(fqdn, value string) error {
+	return d.changeRecord(fqdn, value, true)
+}
+
+// CleanUpRecord removes the value from the TXT record at the FQDN.
+func (d *dnsProvider) CleanUpRecord(fqdn, value string) error {
+	return d.changeRecord(fqdn, value, false)
+}
+
+// changeRecord replaces the TXT record set at the FQDN with the value added or removed,
+// keeping the values of the other challenges in flight.
+func (d *dnsProvider) changeRecord(fqdn, value string, add bool) error {
+	zone, err := d.managedZone(fqdn)
+	if err != nil {
+		return err
+	}
+	change := &dns.Change{}
+	if change.Deletions, err = d.txtRecords(zone, fqdn); err != nil {
+		return err
+	}
+	var values []string
+	for _, record := range change.Deletions {
+		for _, data := range record.Rrdatas {
+			if strings.Trim(data, `"`) != value {
+				values = append(values, data)
+			}
+		}
+	}
+	if add {
+		values = append(values, value)
+	}
+	if len(values) > 0 {
+		change.Additions = []*dns.ResourceRecordSet{{
+			Name:    fqdn,
+			Rrdatas: values,
+			Ttl:     int64(d.ttl),
+			Type:    "TXT",
+		}}
+	}
+	if len(change.Additions) == 0 && len(change.Deletions) == 0 {
+		return nil
+	}
+	chg, err := d.client.Changes.Create(d.project, zone, change).Do()
+	if err != nil {
+		return fmt.Errorf("Failed to change Google Cloud record %s: %v", fqdn, err)
+	}
+	// wait for the change to be acknowledged.
+	for chg.Status == "pending" {
+		time.Sleep(time.Second)
+		if chg, err = d.client.Changes.Get(d.project, zone, chg.Id).Do(); err != nil {
+			return fmt.Errorf("Failed to query Google Cloud change status: %v", err)
+		}
+	}
+	return nil
+}
+
+// Timeout returns the propagation timeout and polling interval.
+func (d *dnsProvider) Timeout() (timeout, interval time.Duration) {
+	return defaultTimeout, defaultInterval
+}
+
+func (d *dnsProvider) managedZone(fqdn string) (string, error) {
+	if d.zone != "" {
+		return d.zone, nil
+	}
+	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", err
+	}
+	zones, err := d.client.ManagedZones.List(d.project).DnsName(authZone).Do()
+	if err != nil {
+		return "", fmt.Errorf("Failed to list Google Cloud managed zones: %v", err)
+	}
+	if len(zones.ManagedZones) == 0 {
+		return "", fmt.Errorf("Zone %s not found in Google Cloud for domain %s", authZone, fqdn)
+	}
+	return zones.ManagedZones[0].Name, nil
+}
+
+func (d *dnsProvider) txtRecords(zone, fqdn string) ([]*dns.ResourceRecordSet, error) {
+	resp, err := d.client.ResourceRecordSets.List(d.project, zone).Name(fqdn).Type("TXT").Do()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list Google Cloud records %s: %v", fqdn, err)
+	}
+	return resp.Rrsets, nil
+}
+
+// httpClient returns a client authenticated with the service account of the config,
+// or with the application default credentials.
+func httpClient(config *dnsprovider.Config) (*http.Client, error) {
+	ctx := context.Background()
+	serviceAccount := []byte(config.Get("service_account", "GCE_SERVICE_ACCOUNT"))
+	if file := config.Get("service_account_file", "GCE_SERVICE_ACCOUNT_FILE"); len(serviceAccount) == 0 && file != "" {
+		var err error
+		if serviceAccount, err = ioutil.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("Error reading Google Cloud service account: %v", err)
+		}
+	}
+	if len(serviceAccount) == 0 {
+		return google.DefaultClient(ctx, dns.NdevClouddnsReadwriteScope)
+	}
+	jwtConfig, err := google.JWTConfigFromJSON(serviceAccount, dns.NdevClouddnsReadwriteScope)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Google Cloud service account: %v", err)
+	}
+	return jwtConfig.Client(ctx), nil
+}
+
+func newDNSProvider(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+	project := config.Get("project", "GCE_PROJECT")
+	if project == "" {
+		return nil, fmt.Errorf("Google Cloud project name missing")
+	}
+	client, err := httpClient(config)
+	if err != nil {
+		return nil, err
+	}
+	service, err := dns.New(client)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create Google Cloud DNS service: %v", err)
+	}
+	provider := &dnsProvider{client: service, project: project, ttl: defaultTTL}
+	if config != nil {
+		provider.zone = config.Zone
+		if config.TTL > 0 {
+			provider.ttl = config.TTL
+		}
+	}
+	return provider, nil
+}
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, newDNSProvider)
+}
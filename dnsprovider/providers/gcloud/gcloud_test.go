@@ -0,0 +1,109 @@
+package gcloud
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/dns/v1"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+// testCloudDNS is a stand-in Cloud DNS API applying the changes to the TXT record sets.
+type testCloudDNS struct {
+	mu      sync.Mutex
+	rrsets  map[string]*dns.ResourceRecordSet
+	changes []*dns.Change
+	paths   []string
+}
+
+func (s *testCloudDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paths = append(s.paths, r.URL.Path)
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/rrsets"):
+		var rrsets []*dns.ResourceRecordSet
+		if rrset, found := s.rrsets[r.URL.Query().Get("name")]; found {
+			rrsets = append(rrsets, rrset)
+		}
+		json.NewEncoder(w).Encode(&dns.ResourceRecordSetsListResponse{Rrsets: rrsets})
+	case strings.HasSuffix(r.URL.Path, "/changes") && r.Method == http.MethodPost:
+		change := &dns.Change{}
+		json.NewDecoder(r.Body).Decode(change)
+		s.changes = append(s.changes, change)
+		for _, rrset := range change.Deletions {
+			delete(s.rrsets, rrset.Name)
+		}
+		for _, rrset := range change.Additions {
+			s.rrsets[rrset.Name] = rrset
+		}
+		change.Status = "done"
+		json.NewEncoder(w).Encode(change)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestChangeRecords(t *testing.T) {
+	api := &testCloudDNS{rrsets: make(map[string]*dns.ResourceRecordSet)}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	service, err := dns.New(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.BasePath = server.URL + "/"
+	d := &dnsProvider{client: service, project: "project", ttl: 30, zone: "example-zone"}
+	fqdn := "_acme-challenge.example.com."
+
+	// the records of the wildcard and base names share the FQDN.
+	if err := d.PresentRecord(fqdn, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PresentRecord(fqdn, "second"); err != nil {
+		t.Fatal(err)
+	}
+	rrset := api.rrsets[fqdn]
+	if rrset == nil || rrset.Ttl != 30 || rrset.Type != "TXT" || !reflect.DeepEqual(rrset.Rrdatas, []string{"first", "second"}) {
+		t.Errorf("Expected both values with the TTL of the config, got %+v", rrset)
+	}
+	if err := d.CleanUpRecord(fqdn, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if rrset := api.rrsets[fqdn]; rrset == nil || !reflect.DeepEqual(rrset.Rrdatas, []string{"second"}) {
+		t.Errorf("Expected the other value kept, got %+v", rrset)
+	}
+	if err := d.CleanUpRecord(fqdn, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if rrset, found := api.rrsets[fqdn]; found {
+		t.Errorf("Expected the record set deleted, got %+v", rrset)
+	}
+	for _, path := range api.paths {
+		if !strings.Contains(path, "/projects/project/managedZones/example-zone/") {
+			t.Errorf("Expected requests to the configured zone, got %s", path)
+		}
+	}
+}
+
+func TestNewDNSProvider(t *testing.T) {
+	tests := []struct {
+		config *dnsprovider.Config
+		err    string
+	}{
+		{&dnsprovider.Config{Credentials: map[string]string{"service_account": "{}"}}, "Google Cloud project name missing"},
+		{&dnsprovider.Config{Credentials: map[string]string{"project": "project", "service_account": "invalid"}}, "Invalid Google Cloud service account"},
+		{&dnsprovider.Config{Credentials: map[string]string{"project": "project", "service_account_file": "/does/not/exist"}}, "Error reading Google Cloud service account"},
+	}
+	for _, test := range tests {
+		if _, err := newDNSProvider(test.config); err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("Expected error %q, got %v", test.err, err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package namecheap
+
+import (
+	"github.com/xenolf/lego/acme"
+	lego "github.com/xenolf/lego/providers/dns/namecheap"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName = "namecheap"
+)
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		if err := config.CheckFields(); err != nil {
+			return nil, err
+		}
+		return lego.NewDNSProviderCredentials(
+			config.Get("api_user", "NAMECHEAP_API_USER"),
+			config.Get("api_key", "NAMECHEAP_API_KEY"),
+		)
+	})
+}
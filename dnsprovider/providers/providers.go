@@ -0,0 +1,20 @@
+package providers
+
+import (
+	// initialise all DNS providers.
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/acmedns"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/cloudflare"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/digitalocean"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/dnsimple"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/dyn"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/embedded"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/exec"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/gandi"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/gcloud"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/manual"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/namecheap"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/rfc2136"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/route53"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/vultr"
+	_ "github.com/jtblin/go-acme/dnsprovider/providers/webhook"
+)
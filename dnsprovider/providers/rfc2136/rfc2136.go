@@ -0,0 +1,42 @@
+package rfc2136
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	lego "github.com/xenolf/lego/providers/dns/rfc2136"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName = "rfc2136"
+)
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		if err := config.CheckFields(); err != nil {
+			return nil, err
+		}
+		provider, err := lego.NewDNSProviderCredentials(
+			config.Get("nameserver", "RFC2136_NAMESERVER"),
+			config.Get("tsig_algorithm", "RFC2136_TSIG_ALGORITHM"),
+			config.Get("tsig_key", "RFC2136_TSIG_KEY"),
+			config.Get("tsig_secret", "RFC2136_TSIG_SECRET"),
+		)
+		if err != nil {
+			return nil, err
+		}
+		value := config.Get("timeout", "RFC2136_TIMEOUT")
+		if value == "" {
+			return provider, nil
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid rfc2136 timeout %q: %v", value, err)
+		}
+		// the propagation timeout of the config, applied by GetProvider, takes precedence.
+		return dnsprovider.WithTimeout(provider, &dnsprovider.Config{Propagation: &dnsprovider.Propagation{Timeout: timeout}}), nil
+	})
+}
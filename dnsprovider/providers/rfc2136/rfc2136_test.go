@@ -0,0 +1,39 @@
+package rfc2136
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+func TestTimeout(t *testing.T) {
+	t.Setenv("RFC2136_TIMEOUT", "3m")
+	tests := []struct {
+		config  *dnsprovider.Config
+		timeout time.Duration
+	}{
+		{&dnsprovider.Config{Credentials: map[string]string{"nameserver": "127.0.0.1"}}, 3 * time.Minute},
+		{&dnsprovider.Config{Credentials: map[string]string{"nameserver": "127.0.0.1", "timeout": "90s"}}, 90 * time.Second},
+		{&dnsprovider.Config{
+			Credentials: map[string]string{"nameserver": "127.0.0.1", "timeout": "90s"},
+			Propagation: &dnsprovider.Propagation{Timeout: time.Minute},
+		}, time.Minute},
+	}
+	for i, test := range tests {
+		provider, err := dnsprovider.InitProvider(providerName, test.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timeout, _ := provider.(acme.ChallengeProviderTimeout).Timeout(); timeout != test.timeout {
+			t.Errorf("%d: expected timeout %s, got %s", i, test.timeout, timeout)
+		}
+	}
+
+	config := &dnsprovider.Config{Credentials: map[string]string{"nameserver": "127.0.0.1", "timeout": "soon"}}
+	if _, err := dnsprovider.InitProvider(providerName, config); err == nil {
+		t.Error("Expected an invalid timeout error")
+	}
+}
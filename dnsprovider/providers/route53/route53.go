@@ -0,0 +1,152 @@
+package route53
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/xenolf/lego/acme"
+	lego "github.com/xenolf/lego/providers/dns/route53"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+const (
+	providerName    = "route53"
+	awsRegionEnv    = "AWS_REGION"
+	defaultRegion   = "us-east-1"
+	defaultTTL      = 10
+	defaultTimeout  = 2 * time.Minute
+	defaultInterval = 4 * time.Second
+)
+
+// dnsProvider is a route53 provider configured programmatically
+// and implements the acme.ChallengeProviderTimeout and dnsprovider.RecordProvider interfaces.
+type dnsProvider struct {
+	client   *route53.Route53
+	ttl      int
+	zoneID   string
+	timeout  time.Duration
+	interval time.Duration
+}
+
+// Present creates the TXT record for the challenge.
+func (d *dnsProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.changeRecord("UPSERT", fqdn, `"`+value+`"`)
+}
+
+// CleanUp removes the TXT record for the challenge.
+func (d *dnsProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.changeRecord("DELETE", fqdn, `"`+value+`"`)
+}
+
+// PresentRecord creates the TXT record at the FQDN.
+func (d *dnsProvider) PresentRecord(fqdn, value string) error {
+	return d.changeRecord("UPSERT", fqdn, `"`+value+`"`)
+}
+
+// CleanUpRecord removes the TXT record at the FQDN.
+func (d *dnsProvider) CleanUpRecord(fqdn, value string) error {
+	return d.changeRecord("DELETE", fqdn, `"`+value+`"`)
+}
+
+// Timeout returns the propagation timeout and polling interval.
+func (d *dnsProvider) Timeout() (timeout, interval time.Duration) {
+	return d.timeout, d.interval
+}
+
+func (d *dnsProvider) changeRecord(action, fqdn, value string) error {
+	zoneID, err := d.hostedZoneID(fqdn)
+	if err != nil {
+		return err
+	}
+	req := &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Comment: aws.String("Managed by go-acme"),
+			Changes: []*route53.Change{{
+				Action: aws.String(action),
+				ResourceRecordSet: &route53.ResourceRecordSet{
+					Name:            aws.String(fqdn),
+					Type:            aws.String("TXT"),
+					TTL:             aws.Int64(int64(d.ttl)),
+					ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(value)}},
+				},
+			}},
+		},
+	}
+	resp, err := d.client.ChangeResourceRecordSets(req)
+	if err != nil {
+		return fmt.Errorf("Failed to %s route53 record %s: %v", strings.ToLower(action), fqdn, err)
+	}
+
+	statusID := resp.ChangeInfo.Id
+	return acme.WaitFor(d.timeout, d.interval, func() (bool, error) {
+		resp, err := d.client.GetChange(&route53.GetChangeInput{Id: statusID})
+		if err != nil {
+			return false, fmt.Errorf("Failed to query route53 change status: %v", err)
+		}
+		return aws.StringValue(resp.ChangeInfo.Status) == route53.ChangeStatusInsync, nil
+	})
+}
+
+func (d *dnsProvider) hostedZoneID(fqdn string) (string, error) {
+	if d.zoneID != "" {
+		return d.zoneID, nil
+	}
+	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", err
+	}
+	resp, err := d.client.ListHostedZonesByName(&route53.ListHostedZonesByNameInput{
+		DNSName: aws.String(acme.UnFqdn(authZone)),
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, zone := range resp.HostedZones {
+		if aws.StringValue(zone.Name) == authZone {
+			return strings.TrimPrefix(aws.StringValue(zone.Id), "/hostedzone/"), nil
+		}
+	}
+	return "", fmt.Errorf("Zone %s not found in route53 for domain %s", authZone, fqdn)
+}
+
+func newDNSProvider(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+	region := config.Get("region", awsRegionEnv)
+	if region == "" {
+		region = defaultRegion
+	}
+	awsConfig := &aws.Config{Region: aws.String(region)}
+	if id := config.Get("access_key_id", ""); id != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(id,
+			config.Get("secret_access_key", ""), config.Get("session_token", ""))
+	}
+	provider := &dnsProvider{
+		client:   route53.New(session.New(awsConfig)),
+		ttl:      defaultTTL,
+		zoneID:   config.Zone,
+		timeout:  defaultTimeout,
+		interval: defaultInterval,
+	}
+	if config.TTL > 0 {
+		provider.ttl = config.TTL
+	}
+	return provider, nil
+}
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		// the lego provider supports neither the zone nor the TTL.
+		if !config.HasCredentials() && (config == nil || (config.Zone == "" && config.TTL == 0)) {
+			return lego.NewDNSProvider()
+		}
+		return newDNSProvider(config)
+	})
+}
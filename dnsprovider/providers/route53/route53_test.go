@@ -0,0 +1,152 @@
+package route53
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+// testChange is a change of the change batch of a ChangeResourceRecordSets request.
+type testChange struct {
+	Action string `xml:"Action"`
+	Name   string `xml:"ResourceRecordSet>Name"`
+	Type   string `xml:"ResourceRecordSet>Type"`
+	TTL    int    `xml:"ResourceRecordSet>TTL"`
+	Value  string `xml:"ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+	// Zone is the hosted zone of the request.
+	Zone string `xml:"-"`
+}
+
+// testZoneID is the only hosted zone of the stand-in API.
+const testZoneID = "Z1D633PJN98FT9"
+
+// testRoute53 is a stand-in route53 API recording the changes, reported in sync after one poll.
+type testRoute53 struct {
+	mu      sync.Mutex
+	changes []testChange
+	polls   map[string]int
+}
+
+func (s *testRoute53) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch path := r.URL.Path; {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/rrset/"):
+		var req struct {
+			Comment string       `xml:"ChangeBatch>Comment"`
+			Changes []testChange `xml:"ChangeBatch>Changes>Change"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		zone := strings.TrimSuffix(strings.TrimPrefix(path, "/2013-04-01/hostedzone/"), "/rrset/")
+		if zone != testZoneID {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>NoSuchHostedZone</Code>`+
+				`<Message>No hosted zone found with ID: %s</Message></Error></ErrorResponse>`, zone)
+			return
+		}
+		for _, change := range req.Changes {
+			change.Zone = zone
+			s.changes = append(s.changes, change)
+		}
+		fmt.Fprintf(w, `<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C%d</Id><Status>PENDING</Status>`+
+			`<SubmittedAt>2017-06-01T00:00:00Z</SubmittedAt></ChangeInfo></ChangeResourceRecordSetsResponse>`, len(s.changes))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/2013-04-01/change/"):
+		id := strings.TrimPrefix(path, "/2013-04-01/change/")
+		s.polls[id]++
+		status := route53.ChangeStatusPending
+		if s.polls[id] > 1 {
+			status = route53.ChangeStatusInsync
+		}
+		fmt.Fprintf(w, `<GetChangeResponse><ChangeInfo><Id>/change/%s</Id><Status>%s</Status>`+
+			`<SubmittedAt>2017-06-01T00:00:00Z</SubmittedAt></ChangeInfo></GetChangeResponse>`, id, status)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestProvider returns the provider of the config sending its requests to the stand-in API.
+func newTestProvider(t *testing.T, config *dnsprovider.Config) (*dnsProvider, *testRoute53) {
+	api := &testRoute53{polls: make(map[string]int)}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	provider, err := newDNSProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := provider.(*dnsProvider)
+	d.client = route53.New(session.New(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String(defaultRegion),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	d.interval = 10 * time.Millisecond
+	return d, api
+}
+
+func TestPresentAndCleanUp(t *testing.T) {
+	d, api := newTestProvider(t, &dnsprovider.Config{
+		Credentials: map[string]string{"access_key_id": "id", "secret_access_key": "secret"},
+		Zone:        testZoneID,
+		TTL:         60,
+	})
+	fqdn, value, _ := acme.DNS01Record("www.example.com", "key-authorization")
+
+	if err := d.Present("www.example.com", "token", "key-authorization"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CleanUp("www.example.com", "token", "key-authorization"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []testChange{
+		{Action: "UPSERT", Name: fqdn, Type: "TXT", TTL: 60, Value: `"` + value + `"`, Zone: testZoneID},
+		{Action: "DELETE", Name: fqdn, Type: "TXT", TTL: 60, Value: `"` + value + `"`, Zone: testZoneID},
+	}
+	if len(api.changes) != len(expected) {
+		t.Fatalf("Expected changes %+v, got %+v", expected, api.changes)
+	}
+	for i, change := range api.changes {
+		if change != expected[i] {
+			t.Errorf("Expected change %+v, got %+v", expected[i], change)
+		}
+	}
+	for id, polls := range api.polls {
+		if polls != 2 {
+			t.Errorf("Expected change %s to be polled until in sync, got %d polls", id, polls)
+		}
+	}
+}
+
+func TestPresentRecord(t *testing.T) {
+	d, api := newTestProvider(t, &dnsprovider.Config{Zone: testZoneID})
+	if err := d.PresentRecord("_acme-challenge.delegated.example.net.", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.changes) != 1 || api.changes[0].Name != "_acme-challenge.delegated.example.net." || api.changes[0].TTL != defaultTTL {
+		t.Errorf("Expected the record at the delegated FQDN with the default TTL, got %+v", api.changes)
+	}
+}
+
+func TestChangeError(t *testing.T) {
+	d, _ := newTestProvider(t, &dnsprovider.Config{Zone: "Z0UNKNOWN"})
+	err := d.Present("www.example.com", "token", "key-authorization")
+	if err == nil || !strings.HasPrefix(err.Error(), "Failed to upsert route53 record _acme-challenge.www.example.com.") {
+		t.Errorf("Expected an upsert error, got %v", err)
+	}
+}
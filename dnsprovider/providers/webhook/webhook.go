@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+	"github.com/jtblin/go-acme/internal/tlsconfig"
+)
+
+const (
+	providerName    = "webhook"
+	presentURLEnv   = "WEBHOOK_PRESENT_URL"
+	cleanupURLEnv   = "WEBHOOK_CLEANUP_URL"
+	bearerTokenEnv  = "WEBHOOK_BEARER_TOKEN"
+	hmacSecretEnv   = "WEBHOOK_HMAC_SECRET"
+	retriesEnv      = "WEBHOOK_RETRIES"
+	timeoutEnv      = "WEBHOOK_TIMEOUT"
+	caFileEnv       = "WEBHOOK_CA_FILE"
+	certFileEnv     = "WEBHOOK_CERT_FILE"
+	keyFileEnv      = "WEBHOOK_KEY_FILE"
+	insecureEnv     = "WEBHOOK_INSECURE_SKIP_VERIFY"
+	signatureHeader = "X-Webhook-Signature"
+	timestampHeader = "X-Webhook-Timestamp"
+	defaultRetries  = 3
+	defaultTTL      = 120
+	defaultTimeout  = 30 * time.Second
+)
+
+// retryBackoffFactor is multiplied by the attempt number to wait between two attempts.
+var retryBackoffFactor = time.Second
+
+// timeNow returns the time of the signature timestamps.
+var timeNow = time.Now
+
+// record is the JSON payload posted to the webhook.
+type record struct {
+	FQDN  string `json:"fqdn"`
+	Value string `json:"value"`
+	TTL   int    `json:"ttl"`
+}
+
+// dnsProvider posts the challenge records to a webhook
+// and implements the acme.ChallengeProvider and dnsprovider.RecordProvider interfaces.
+type dnsProvider struct {
+	client      *http.Client
+	presentURL  string
+	cleanupURL  string
+	bearerToken string
+	hmacSecret  []byte
+	retries     int
+	ttl         int
+}
+
+// Present posts the challenge record to the present url.
+func (d *dnsProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.post(d.presentURL, fqdn, value)
+}
+
+// CleanUp posts the challenge record to the cleanup url.
+func (d *dnsProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.post(d.cleanupURL, fqdn, value)
+}
+
+// PresentRecord posts the record at the FQDN to the present url.
+func (d *dnsProvider) PresentRecord(fqdn, value string) error {
+	return d.post(d.presentURL, fqdn, value)
+}
+
+// CleanUpRecord posts the record at the FQDN to the cleanup url.
+func (d *dnsProvider) CleanUpRecord(fqdn, value string) error {
+	return d.post(d.cleanupURL, fqdn, value)
+}
+
+func (d *dnsProvider) post(url, fqdn, value string) error {
+	ttl := defaultTTL
+	if d.ttl > 0 {
+		ttl = d.ttl
+	}
+	body, err := json.Marshal(record{FQDN: fqdn, Value: value, TTL: ttl})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = d.do(url, body); err == nil || !retry || attempt >= d.retries {
+			break
+		}
+		time.Sleep(time.Duration(attempt+1) * retryBackoffFactor)
+	}
+	if err != nil {
+		return fmt.Errorf("Webhook %s for %s failed: %v", url, fqdn, err)
+	}
+	return nil
+}
+
+// do posts the body and returns whether the request can be retried on error.
+func (d *dnsProvider) do(url string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+d.bearerToken)
+	}
+	if len(d.hmacSecret) > 0 {
+		timestamp := strconv.FormatInt(timeNow().Unix(), 10)
+		req.Header.Set(timestampHeader, timestamp)
+		req.Header.Set(signatureHeader, "sha256="+sign(d.hmacSecret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// sign returns the hex HMAC-SHA256 of the timestamp and body joined by a dot, so that
+// a captured request cannot be replayed once the receiver's freshness window has passed.
+func sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newDNSProvider(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+	if err := config.CheckFields(dnsprovider.FieldTTL); err != nil {
+		return nil, err
+	}
+	presentURL, cleanupURL := config.Get("present_url", presentURLEnv), config.Get("cleanup_url", cleanupURLEnv)
+	if presentURL == "" || cleanupURL == "" {
+		return nil, errors.New("Webhook DNS provider present and cleanup urls missing")
+	}
+	retries := defaultRetries
+	if value := config.Get("retries", retriesEnv); value != "" {
+		var err error
+		if retries, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("Invalid webhook retries %q: %v", value, err)
+		}
+	}
+	timeout := defaultTimeout
+	if value := config.Get("timeout", timeoutEnv); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("Invalid webhook timeout %q: %v", value, err)
+		}
+	}
+	tlsConfig, err := tlsconfig.New(config.Get("ca_file", caFileEnv), config.Get("cert_file", certFileEnv),
+		config.Get("key_file", keyFileEnv), config.Get("insecure_skip_verify", insecureEnv))
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	provider := &dnsProvider{
+		client:      &http.Client{Transport: transport, Timeout: timeout},
+		presentURL:  presentURL,
+		cleanupURL:  cleanupURL,
+		bearerToken: config.Get("bearer_token", bearerTokenEnv),
+		hmacSecret:  []byte(config.Get("hmac_secret", hmacSecretEnv)),
+		retries:     retries,
+	}
+	if config != nil {
+		provider.ttl = config.TTL
+	}
+	return provider, nil
+}
+
+func init() {
+	dnsprovider.RegisterProvider(providerName, func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		return newDNSProvider(config)
+	})
+}
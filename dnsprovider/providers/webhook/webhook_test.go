@@ -0,0 +1,246 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+// testWebhook records the requests received and replies with the statuses in order,
+// then with 200 once they are exhausted.
+type testWebhook struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newTestWebhook(t *testing.T, statuses ...int) *testWebhook {
+	w := &testWebhook{statuses: statuses}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.requests = append(w.requests, r)
+		w.bodies = append(w.bodies, body)
+		status := http.StatusOK
+		if len(w.statuses) > 0 {
+			status, w.statuses = w.statuses[0], w.statuses[1:]
+		}
+		rw.WriteHeader(status)
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *testWebhook) calls() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.requests)
+}
+
+// received returns the i-th request and its body.
+func (w *testWebhook) received(t *testing.T, i int) (*http.Request, []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if i >= len(w.requests) {
+		t.Fatalf("Expected at least %d requests, got %d", i+1, len(w.requests))
+	}
+	return w.requests[i], w.bodies[i]
+}
+
+func newTestProvider(t *testing.T, w *testWebhook, credentials map[string]string) *dnsProvider {
+	retryBackoffFactor = time.Millisecond
+	t.Cleanup(func() { retryBackoffFactor = time.Second })
+	config := &dnsprovider.Config{Credentials: map[string]string{
+		"present_url": w.URL + "/present",
+		"cleanup_url": w.URL + "/cleanup",
+	}}
+	for key, value := range credentials {
+		config.Credentials[key] = value
+	}
+	provider, err := newDNSProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.(*dnsProvider)
+}
+
+func TestPayload(t *testing.T) {
+	w := newTestWebhook(t)
+	provider := newTestProvider(t, w, nil)
+	if err := provider.Present("example.com", "token", "key-auth"); err != nil {
+		t.Fatalf("Expected present to succeed, got %v", err)
+	}
+	if err := provider.CleanUpRecord("_acme-challenge.example.org.", "value"); err != nil {
+		t.Fatalf("Expected cleanup to succeed, got %v", err)
+	}
+	if w.calls() != 2 {
+		t.Fatalf("Expected 2 requests, got %d", w.calls())
+	}
+
+	for i, path := range []string{"/present", "/cleanup"} {
+		if r, _ := w.received(t, i); r.Method != http.MethodPost || r.URL.Path != path || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected JSON post to %s, got %s %s %s", path, r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+	}
+	var present, cleanup record
+	r, body := w.received(t, 0)
+	if err := json.Unmarshal(body, &present); err != nil {
+		t.Fatal(err)
+	}
+	if present.FQDN != "_acme-challenge.example.com." || present.Value == "" || present.TTL != defaultTTL {
+		t.Errorf("Unexpected present payload %s", body)
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		t.Errorf("Expected no authorization header, got %q", auth)
+	}
+	if signature := r.Header.Get(signatureHeader); signature != "" {
+		t.Errorf("Expected no signature header, got %q", signature)
+	}
+	_, body = w.received(t, 1)
+	if err := json.Unmarshal(body, &cleanup); err != nil {
+		t.Fatal(err)
+	}
+	if cleanup != (record{FQDN: "_acme-challenge.example.org.", Value: "value", TTL: defaultTTL}) {
+		t.Errorf("Unexpected cleanup payload %s", body)
+	}
+}
+
+func TestConfiguredTTL(t *testing.T) {
+	w := newTestWebhook(t)
+	provider := newTestProvider(t, w, nil)
+	provider.ttl = 60
+	if err := provider.PresentRecord("_acme-challenge.example.com.", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := w.received(t, 0); !strings.Contains(string(body), `"ttl":60`) {
+		t.Errorf("Expected a ttl of 60, got %s", body)
+	}
+}
+
+func TestBearerAndHMACHeaders(t *testing.T) {
+	w := newTestWebhook(t)
+	provider := newTestProvider(t, w, map[string]string{"bearer_token": "secret-token", "hmac_secret": "shared"})
+	if err := provider.PresentRecord("_acme-challenge.example.com.", "value"); err != nil {
+		t.Fatal(err)
+	}
+	r, body := w.received(t, 0)
+	if auth := r.Header.Get("Authorization"); auth != "Bearer secret-token" {
+		t.Errorf("Expected bearer token, got %q", auth)
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(timestampHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("Expected a unix timestamp header, got %q", r.Header.Get(timestampHeader))
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age < -time.Second || age > time.Minute {
+		t.Errorf("Expected a current timestamp, got %s old", age)
+	}
+	mac := hmac.New(sha256.New, []byte("shared"))
+	mac.Write([]byte(r.Header.Get(timestampHeader) + "."))
+	mac.Write(body)
+	if expected, signature := "sha256="+hex.EncodeToString(mac.Sum(nil)), r.Header.Get(signatureHeader); signature != expected {
+		t.Errorf("Expected signature %q of the timestamp and body, got %q", expected, signature)
+	}
+}
+
+func TestSignatureTimestamp(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	timeNow = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	defer func() { timeNow = time.Now }()
+	w := newTestWebhook(t, http.StatusServiceUnavailable)
+	provider := newTestProvider(t, w, map[string]string{"hmac_secret": "shared"})
+	if err := provider.PresentRecord("_acme-challenge.example.com.", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	first, body := w.received(t, 0)
+	retry, _ := w.received(t, 1)
+	if first.Header.Get(timestampHeader) != "1500000060" || retry.Header.Get(timestampHeader) != "1500000120" {
+		t.Errorf("Expected each attempt to carry its own timestamp, got %q and %q",
+			first.Header.Get(timestampHeader), retry.Header.Get(timestampHeader))
+	}
+	if first.Header.Get(signatureHeader) == retry.Header.Get(signatureHeader) {
+		t.Error("Expected the retry to be signed with its timestamp")
+	}
+	// the body replayed with another timestamp does not match the signature.
+	if replayed := "sha256=" + sign([]byte("shared"), "1500000600", body); replayed == first.Header.Get(signatureHeader) {
+		t.Error("Expected the signature to cover the timestamp")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := map[string][]int{
+		"server error":      {http.StatusInternalServerError, http.StatusBadGateway},
+		"too many requests": {http.StatusTooManyRequests},
+	}
+	for name, statuses := range tests {
+		w := newTestWebhook(t, statuses...)
+		provider := newTestProvider(t, w, nil)
+		if err := provider.PresentRecord("_acme-challenge.example.com.", "value"); err != nil {
+			t.Errorf("%s: expected success after retrying, got %v", name, err)
+		}
+		if w.calls() != len(statuses)+1 {
+			t.Errorf("%s: expected %d requests, got %d", name, len(statuses)+1, w.calls())
+		}
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	w := newTestWebhook(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	provider := newTestProvider(t, w, map[string]string{"retries": "2"})
+	err := provider.PresentRecord("_acme-challenge.example.com.", "value")
+	if err == nil || !strings.Contains(err.Error(), "unexpected status 503") {
+		t.Errorf("Expected the last status in the error, got %v", err)
+	}
+	if w.calls() != 3 {
+		t.Errorf("Expected 3 requests, got %d", w.calls())
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound} {
+		w := newTestWebhook(t, status)
+		provider := newTestProvider(t, w, nil)
+		if err := provider.PresentRecord("_acme-challenge.example.com.", "value"); err == nil {
+			t.Errorf("Expected an error on status %d", status)
+		}
+		if w.calls() != 1 {
+			t.Errorf("Expected no retry on status %d, got %d requests", status, w.calls())
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := map[string]map[string]string{
+		"missing urls":     {},
+		"invalid retries":  {"present_url": "http://localhost", "cleanup_url": "http://localhost", "retries": "many"},
+		"invalid timeout":  {"present_url": "http://localhost", "cleanup_url": "http://localhost", "timeout": "soon"},
+		"invalid insecure": {"present_url": "http://localhost", "cleanup_url": "http://localhost", "insecure_skip_verify": "maybe"},
+	}
+	for name, credentials := range tests {
+		t.Setenv(presentURLEnv, "")
+		t.Setenv(cleanupURLEnv, "")
+		if _, err := newDNSProvider(&dnsprovider.Config{Credentials: credentials}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
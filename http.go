@@ -0,0 +1,167 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// legoHTTPClient is the default http client of the lego client.
+var legoHTTPClient = acme.HTTPClient
+
+// The lego client only has a package http client and user agent, shared by all the ACME instances.
+// Rather than swapping them for each call, which would serialize the calls of every instance,
+// the lego client is set once to route its requests by CA host to the http client and user agent
+// of the instance using that CA.
+var legoRoutesMu sync.RWMutex
+var legoRoutes = make(map[string]*legoRoute)
+var installLegoTransport sync.Once
+
+// legoRoute is the http client and user agent of the requests to a CA host.
+type legoRoute struct {
+	owner     *ACME
+	client    *http.Client
+	userAgent string
+}
+
+// legoTransport sends the requests of the lego client with the http client of their CA host,
+// or the default lego client for the other hosts e.g. the OCSP responders.
+type legoTransport struct{}
+
+// RoundTrip sends the request with the http client of its host, appending the user agent.
+func (legoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	legoRoutesMu.RLock()
+	route, found := legoRoutes[req.URL.Host]
+	legoRoutesMu.RUnlock()
+	if !found {
+		transport := legoHTTPClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		return transport.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if route.userAgent != "" {
+		req.Header.Set("User-Agent", strings.TrimSpace(req.Header.Get("User-Agent")+" "+route.userAgent))
+	}
+	return route.client.Do(req)
+}
+
+// routeLegoRequests routes the lego requests to the CA host through the http client of the
+// instance until the context is done. The CA host is left to the default lego client without
+// a custom http client or user agent.
+func (a *ACME) routeLegoRequests(ctx context.Context) error {
+	if a.HTTPClient == nil && a.Transport == nil && len(a.RootCAs) == 0 && a.UserAgent == "" {
+		return nil
+	}
+	u, err := url.Parse(a.caServerURL())
+	if err != nil {
+		return fmt.Errorf("Invalid CA server %q: %v", a.caServerURL(), err)
+	}
+	host := u.Host
+	route := &legoRoute{owner: a, client: a.legoClient(), userAgent: a.UserAgent}
+	installLegoTransport.Do(func() {
+		acme.HTTPClient.Transport = legoTransport{}
+	})
+	legoRoutesMu.Lock()
+	if previous, found := legoRoutes[host]; found && previous.owner != a {
+		a.Logger.Printf("Replacing the http client of the ACME requests to %q set by another instance\n", host)
+	}
+	legoRoutes[host] = route
+	legoRoutesMu.Unlock()
+	go func() {
+		<-ctx.Done()
+		legoRoutesMu.Lock()
+		defer legoRoutesMu.Unlock()
+		if legoRoutes[host] == route {
+			delete(legoRoutes, host)
+		}
+	}()
+	return nil
+}
+
+// legoClient returns the http client created by CreateConfig, or the default lego client.
+func (a *ACME) legoClient() *http.Client {
+	if a.httpClient != nil {
+		return a.httpClient
+	}
+	client := legoHTTPClient
+	return &client
+}
+
+// newHTTPClient returns the http client to use for the requests to the CA with the transport
+// and root CAs configured, or the default lego client if none is.
+func (a *ACME) newHTTPClient() (*http.Client, error) {
+	if a.HTTPClient == nil && a.Transport == nil && len(a.RootCAs) == 0 {
+		client := legoHTTPClient
+		return &client, nil
+	}
+	client := &http.Client{}
+	if a.HTTPClient != nil {
+		*client = *a.HTTPClient
+	}
+	if a.Transport != nil {
+		client.Transport = a.Transport
+	}
+	roots, err := a.trustRoots()
+	if err != nil {
+		return nil, err
+	}
+	if client.Transport == nil {
+		client.Transport = defaultTransport()
+	}
+	if roots == nil {
+		return client, nil
+	}
+
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("Root CAs can only be set on an *http.Transport")
+	}
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.RootCAs = roots
+	client.Transport = transport
+	return client, nil
+}
+
+// defaultTransport returns a transport with the same settings as the lego client.
+func defaultTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// trustRoots returns the system roots with RootCAs appended, or nil if none are configured.
+func (a *ACME) trustRoots() (*x509.CertPool, error) {
+	if len(a.RootCAs) == 0 {
+		return nil, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(a.RootCAs) {
+		return nil, errors.New("No valid root CA found in RootCAs")
+	}
+	return pool, nil
+}
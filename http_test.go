@@ -0,0 +1,117 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/types"
+)
+
+func TestInvalidRootCAs(t *testing.T) {
+	a := &ACME{CAServer: "https://ca.example.com/directory", RootCAs: []byte("invalid")}
+	if _, err := a.newHTTPClient(); err == nil {
+		t.Error("Expected an error for invalid root CAs")
+	}
+}
+
+func TestRootCAsAndUserAgent(t *testing.T) {
+	server, root := newTestTLSACMEServer(t)
+	a := &ACME{
+		Email:      "user@example.com",
+		Domain:     &types.Domain{Main: "www.example.com"},
+		Backend:    &memoryBackend{},
+		Challenges: []acme.Challenge{acme.HTTP01},
+		CAServer:   server.directory(),
+		RootCAs:    root,
+		UserAgent:  "test-agent/1.0",
+		Logger:     log.New(ioutil.Discard, "", 0),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := a.CreateConfigContext(ctx, &tls.Config{}); err != nil {
+		t.Fatalf("Expected certificate from the CA trusted with RootCAs, got %v", err)
+	}
+	if a.certificate() == nil {
+		t.Fatal("Expected a certificate to be loaded")
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.userAgents) == 0 {
+		t.Fatal("Expected requests to the CA")
+	}
+	for _, userAgent := range server.userAgents {
+		if !strings.Contains(userAgent, "test-agent/1.0") {
+			t.Errorf("Expected the user agent in the User-Agent header, got %q", userAgent)
+		}
+	}
+	if acme.UserAgent != "" {
+		t.Errorf("Expected the lego user agent to be left unset, got %q", acme.UserAgent)
+	}
+
+	// the CA is not trusted by the default client.
+	other := &ACME{CAServer: a.CAServer}
+	if _, err := other.legoClient().Get(server.directory()); err == nil {
+		t.Error("Expected the CA not to be trusted without RootCAs")
+	}
+}
+
+func TestLegoRoutes(t *testing.T) {
+	userAgents := make(chan string, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.UserAgent()
+	})
+	first, second := httptest.NewServer(handler), httptest.NewServer(handler)
+	t.Cleanup(first.Close)
+	t.Cleanup(second.Close)
+	firstCtx, cancel := context.WithCancel(context.Background())
+	instances := []struct {
+		ctx context.Context
+		a   *ACME
+	}{
+		{firstCtx, &ACME{CAServer: first.URL + "/directory", UserAgent: "first/1.0"}},
+		{context.Background(), &ACME{CAServer: second.URL + "/directory", UserAgent: "second/1.0"}},
+	}
+	for _, instance := range instances {
+		if err := instance.a.routeLegoRequests(instance.ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(url string) string {
+		resp, err := acme.HTTPClient.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return <-userAgents
+	}
+	if userAgent := get(first.URL); !strings.HasSuffix(userAgent, "first/1.0") {
+		t.Errorf("Expected the user agent of the first instance, got %q", userAgent)
+	}
+	if userAgent := get(second.URL); !strings.HasSuffix(userAgent, "second/1.0") {
+		t.Errorf("Expected the user agent of the second instance, got %q", userAgent)
+	}
+
+	cancel()
+	for i := 0; i < 100; i++ {
+		legoRoutesMu.RLock()
+		_, found := legoRoutes[strings.TrimPrefix(first.URL, "http://")]
+		legoRoutesMu.RUnlock()
+		if !found {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if userAgent := get(first.URL); strings.Contains(userAgent, "first/1.0") {
+		t.Errorf("Expected the default lego client once the context is done, got %q", userAgent)
+	}
+}
@@ -0,0 +1,43 @@
+// Package tlsconfig builds the TLS client configuration shared by the DNS providers and storage
+// backends connecting to services with a private CA or client certificates.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// New returns the TLS configuration trusting the PEM certificates of the CA file, presenting
+// the client certificate of the cert and key files and skipping the verification of the server
+// certificate if insecureSkipVerify parses as true. Empty values are ignored.
+func New(caFile, certFile, keyFile, insecureSkipVerify string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No valid certificate found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if insecureSkipVerify != "" {
+		skip, err := strconv.ParseBool(insecureSkipVerify)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid insecure_skip_verify %q: %v", insecureSkipVerify, err)
+		}
+		tlsConfig.InsecureSkipVerify = skip
+	}
+	return tlsConfig, nil
+}
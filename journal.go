@@ -0,0 +1,141 @@
+package acme
+
+import (
+	"context"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/types"
+)
+
+// challengeValidationTime is the time allowed to the CA to validate a challenge once its
+// record has propagated, after which a journaled record is considered left over.
+const challengeValidationTime = 10 * time.Minute
+
+// journalingProvider journals the challenge records in the account before presenting them
+// so that the records left over by a crash are cleaned up on the next start,
+// and implements the acme.ChallengeProviderTimeout interface.
+type journalingProvider struct {
+	acme.ChallengeProvider
+	acme *ACME
+	key  string
+	// resolvers are the addresses of the resolvers of the provider's propagation check.
+	resolvers []string
+}
+
+// Present journals then presents the challenge record.
+func (p *journalingProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	err := p.acme.journal(func(account *types.Account) bool {
+		account.AddChallenge(&types.Challenge{
+			Provider: p.key,
+			Domain:   domain,
+			FQDN:     fqdn,
+			Token:    token,
+			KeyAuth:  keyAuth,
+			Value:    value,
+			Time:     time.Now(),
+			Owner:    p.acme.instanceID,
+		})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return p.ChallengeProvider.Present(domain, token, keyAuth)
+}
+
+// CleanUp cleans up the challenge record and removes it from the journal.
+func (p *journalingProvider) CleanUp(domain, token, keyAuth string) error {
+	if err := p.ChallengeProvider.CleanUp(domain, token, keyAuth); err != nil {
+		return err
+	}
+	return p.acme.journal(func(account *types.Account) bool {
+		return account.RemoveChallenge(p.key, domain, keyAuth)
+	})
+}
+
+// Timeout returns the timeout and interval of the provider.
+func (p *journalingProvider) Timeout() (timeout, interval time.Duration) {
+	if provider, ok := p.ChallengeProvider.(acme.ChallengeProviderTimeout); ok {
+		return provider.Timeout()
+	}
+	return 60 * time.Second, 2 * time.Second
+}
+
+// journal updates the journal of the account and saves the account if fn returns true.
+func (a *ACME) journal(fn func(account *types.Account) bool) error {
+	a.journalMu.Lock()
+	defer a.journalMu.Unlock()
+	account := a.loadedAccount()
+	if account == nil || !fn(account) {
+		return nil
+	}
+	return a.saveAccount(a.ctx, account)
+}
+
+// cleanUpJournal cleans up the challenge records journaled by a previous run. The domain is
+// locked so that the records of an order in flight on another replica are not removed. Without
+// a storage backend lock, only the records of this instance and the records older than the
+// challenge timeout are cleaned up.
+func (a *ACME) cleanUpJournal(ctx context.Context) {
+	a.journalMu.Lock()
+	journaled := len(a.loadedAccount().Challenges)
+	a.journalMu.Unlock()
+	if journaled == 0 {
+		return
+	}
+	_, unlock, locked, err := a.lockDomain(ctx)
+	if err != nil {
+		a.Logger.Printf("Error locking %q to clean up the challenge journal: %s\n", a.Domain.Main, err.Error())
+		return
+	}
+	defer unlock()
+
+	var challenges []*types.Challenge
+	a.journalMu.Lock()
+	for _, c := range a.loadedAccount().Challenges {
+		if locked || (c.Owner != "" && c.Owner == a.instanceID) || a.leftOver(c) {
+			challenges = append(challenges, c)
+		}
+	}
+	a.journalMu.Unlock()
+	if len(challenges) < journaled {
+		a.Logger.Printf("Keeping %d challenge records of %q which may be in flight on another instance\n",
+			journaled-len(challenges), a.Domain.Main)
+	}
+	for _, c := range challenges {
+		a.Logger.Printf("Cleaning up challenge record %s left over from %s...\n", c.FQDN, c.Time.Format(time.RFC3339))
+		provider, found := a.dnsProviders[c.Provider]
+		if p, ok := provider.(*journalingProvider); ok {
+			// the journal is saved once all the records are cleaned up.
+			provider = p.ChallengeProvider
+		}
+		if !found {
+			a.Logger.Printf("No DNS provider %q to clean up challenge record %s\n", c.Provider, c.FQDN)
+		} else if err := provider.CleanUp(c.Domain, c.Token, c.KeyAuth); err != nil {
+			a.Logger.Printf("Error cleaning up challenge record %s: %v\n", c.FQDN, err)
+		}
+	}
+	// the records are not retried, some providers fail to delete records which do not exist anymore.
+	err = a.journal(func(account *types.Account) bool {
+		for _, c := range challenges {
+			account.RemoveChallenge(c.Provider, c.Domain, c.KeyAuth)
+		}
+		return true
+	})
+	if err != nil {
+		a.Logger.Printf("Error saving challenge journal: %v\n", err)
+	}
+}
+
+// leftOver returns true if the challenge record is older than the propagation timeout of its
+// provider and the validation time, so that no order can still be waiting for it.
+func (a *ACME) leftOver(c *types.Challenge) bool {
+	timeout := 60 * time.Second
+	if provider, ok := a.dnsProviders[c.Provider].(acme.ChallengeProviderTimeout); ok {
+		timeout, _ = provider.Timeout()
+	}
+	return time.Since(c.Time) > timeout+challengeValidationTime
+}
@@ -0,0 +1,153 @@
+package acme
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+// lockingBackend is a memory backend recording whether the domain lock is held.
+type lockingBackend struct {
+	memoryBackend
+	locked bool
+	locks  int
+}
+
+func (b *lockingBackend) LoadAccountContext(ctx context.Context, domain string) (*types.Account, error) {
+	return b.LoadAccount(domain)
+}
+
+func (b *lockingBackend) SaveAccountContext(ctx context.Context, account *types.Account) error {
+	return b.SaveAccount(account)
+}
+
+func (b *lockingBackend) Lock(ctx context.Context, domain string) (context.Context, func() error, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.locked = true
+	b.locks++
+	return ctx, func() error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.locked = false
+		return nil
+	}, nil
+}
+
+// lockCheckingProvider records the clean ups made without the domain lock.
+type lockCheckingProvider struct {
+	testDNSProvider
+	backend  *lockingBackend
+	unlocked int
+}
+
+func (p *lockCheckingProvider) CleanUp(domain, token, keyAuth string) error {
+	p.backend.mu.Lock()
+	if !p.backend.locked {
+		p.unlocked++
+	}
+	p.backend.mu.Unlock()
+	return p.testDNSProvider.CleanUp(domain, token, keyAuth)
+}
+
+func TestCleanUpJournal(t *testing.T) {
+	b := &lockingBackend{}
+	a := &ACME{
+		Domain: &types.Domain{Main: "www.example.com"},
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+	a.ctx = context.Background()
+	a.storage = backend.WithContext(b)
+	provider := &lockCheckingProvider{backend: b}
+	a.dnsProviders = map[string]acme.ChallengeProvider{
+		"": &journalingProvider{ChallengeProvider: provider, acme: a, key: ""},
+	}
+	a.account = &types.Account{DomainsCertificate: &types.DomainCertificate{Domain: a.Domain}}
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		fqdn, value, _ := acme.DNS01Record(domain, "key-auth-"+domain)
+		a.account.AddChallenge(&types.Challenge{Domain: domain, FQDN: fqdn, Token: "token", KeyAuth: "key-auth-" + domain, Value: value, Time: time.Now()})
+	}
+
+	a.cleanUpJournal(context.Background())
+
+	if provider.cleanedUp != 3 {
+		t.Errorf("Expected the 3 journaled records to be cleaned up, got %d", provider.cleanedUp)
+	}
+	if provider.unlocked != 0 || b.locks != 1 {
+		t.Errorf("Expected the records to be cleaned up holding the domain lock once, got %d without lock and %d locks", provider.unlocked, b.locks)
+	}
+	if len(a.account.Challenges) != 0 {
+		t.Errorf("Expected an empty journal, got %d records", len(a.account.Challenges))
+	}
+	stored, err := b.LoadAccount("www.example.com")
+	if err != nil || stored == nil {
+		t.Fatalf("Expected the journal to be saved, got %v", err)
+	}
+	if len(stored.Challenges) != 0 {
+		t.Errorf("Expected an empty saved journal, got %d records", len(stored.Challenges))
+	}
+}
+
+func TestCleanUpJournalWithoutLock(t *testing.T) {
+	b := &memoryBackend{}
+	a := &ACME{
+		Domain:     &types.Domain{Main: "www.example.com"},
+		Logger:     log.New(ioutil.Discard, "", 0),
+		instanceID: "this-instance",
+	}
+	a.ctx = context.Background()
+	a.storage = backend.WithContext(b)
+	provider := &testDNSProvider{}
+	a.dnsProviders = map[string]acme.ChallengeProvider{
+		"": &journalingProvider{ChallengeProvider: provider, acme: a, key: ""},
+	}
+	a.account = &types.Account{DomainsCertificate: &types.DomainCertificate{Domain: a.Domain}}
+	journal := []struct {
+		domain string
+		owner  string
+		age    time.Duration
+	}{
+		{"own.example.com", "this-instance", time.Minute},
+		{"in-flight.example.com", "other-instance", time.Minute},
+		{"left-over.example.com", "other-instance", time.Hour},
+	}
+	for _, c := range journal {
+		provider.Present(c.domain, "token", "key-auth")
+		a.account.AddChallenge(&types.Challenge{Domain: c.domain, Token: "token", KeyAuth: "key-auth", Owner: c.owner, Time: time.Now().Add(-c.age)})
+	}
+
+	a.cleanUpJournal(context.Background())
+
+	if provider.cleanedUp != 2 || provider.keyAuth("in-flight.example.com") == "" {
+		t.Errorf("Expected the own and left over records cleaned up, got %d clean ups and %v left", provider.cleanedUp, provider.keyAuths)
+	}
+	if len(a.account.Challenges) != 1 || a.account.Challenges[0].Domain != "in-flight.example.com" {
+		t.Errorf("Expected the record in flight on the other instance kept in the journal, got %+v", a.account.Challenges)
+	}
+}
+
+func TestRemoveChallengeKeepsSnapshot(t *testing.T) {
+	account := &types.Account{}
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		account.AddChallenge(&types.Challenge{Domain: domain, KeyAuth: domain})
+	}
+	snapshot := account.Challenges
+	if !account.RemoveChallenge("", "a.example.com", "a.example.com") {
+		t.Fatal("Expected the challenge to be removed")
+	}
+	if len(account.Challenges) != 2 {
+		t.Errorf("Expected 2 challenges left, got %d", len(account.Challenges))
+	}
+	for i, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		if snapshot[i].Domain != domain {
+			t.Errorf("Expected the snapshot to be unchanged, got %s at %d", snapshot[i].Domain, i)
+		}
+	}
+}
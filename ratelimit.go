@@ -0,0 +1,157 @@
+package acme
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/jtblin/go-acme/types"
+)
+
+// DefaultRateLimits are the Let's Encrypt published rate limits.
+var DefaultRateLimits = RateLimits{
+	CertificatesPerDomain: 50,
+	DuplicateCertificates: 5,
+	Window:                7 * 24 * time.Hour,
+	OrdersPerAccount:      300,
+	AccountWindow:         3 * time.Hour,
+}
+
+// RateLimits holds the limits enforced per CA on certificate orders, a zero limit disables the check.
+// Orders are recorded in the account stored in the backend so they are only counted for the main
+// domain of the ACME instance.
+type RateLimits struct {
+	// CertificatesPerDomain is the max number of new certificates per registered domain in Window.
+	CertificatesPerDomain int
+	// DuplicateCertificates is the max number of certificates for the exact same names in Window.
+	DuplicateCertificates int
+	// Window is the sliding window of the certificate limits.
+	Window time.Duration
+	// OrdersPerAccount is the max number of orders per account in AccountWindow.
+	OrdersPerAccount int
+	// AccountWindow is the sliding window of the account limit.
+	AccountWindow time.Duration
+}
+
+// RateLimitError is returned when an order would exceed a rate limit.
+type RateLimitError struct {
+	// Limit is the name of the limit exceeded.
+	Limit string
+	// Key is the registered domain, account or names the limit applies to.
+	Key        string
+	Count      int
+	RetryAfter time.Time
+}
+
+// Error implements the error interface.
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Rate limit %q of %d orders exceeded for %s, retry after %s",
+		e.Limit, e.Count, e.Key, e.RetryAfter.Format(time.RFC3339))
+}
+
+func (a *ACME) rateLimits() RateLimits {
+	if a.RateLimits != nil {
+		return *a.RateLimits
+	}
+	return DefaultRateLimits
+}
+
+// checkRateLimits returns a *RateLimitError if a new order would exceed the rate limits.
+func (a *ACME) checkRateLimits(account *types.Account, order *types.Order) error {
+	limits := a.rateLimits()
+	names := nameSet(order.Names)
+	domains := registeredDomains(order.Names)
+
+	var accountOrders, duplicates []time.Time
+	domainCerts := make(map[string][]time.Time)
+	for _, o := range account.Orders {
+		if o.CAServer != order.CAServer {
+			continue
+		}
+		if o.Account == order.Account && order.Time.Sub(o.Time) < limits.AccountWindow {
+			accountOrders = append(accountOrders, o.Time)
+		}
+		if !o.Issued || order.Time.Sub(o.Time) >= limits.Window {
+			continue
+		}
+		if nameSet(o.Names) == names {
+			duplicates = append(duplicates, o.Time)
+		}
+		if o.Renewal {
+			continue
+		}
+		for domain := range registeredDomains(o.Names) {
+			if domains[domain] {
+				domainCerts[domain] = append(domainCerts[domain], o.Time)
+			}
+		}
+	}
+
+	if err := exceeded("OrdersPerAccount", order.Account, accountOrders, limits.OrdersPerAccount, limits.AccountWindow); err != nil {
+		return err
+	}
+	if err := exceeded("DuplicateCertificates", names, duplicates, limits.DuplicateCertificates, limits.Window); err != nil {
+		return err
+	}
+	if order.Renewal {
+		return nil
+	}
+	for domain, times := range domainCerts {
+		if err := exceeded("CertificatesPerDomain", domain, times, limits.CertificatesPerDomain, limits.Window); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordOrder checks the rate limits and saves the order in the ledger before it is sent to the CA.
+func (a *ACME) recordOrder(ctx context.Context, account *types.Account, order *types.Order) error {
+	if err := a.checkRateLimits(account, order); err != nil {
+		return err
+	}
+	limits := a.rateLimits()
+	maxAge := limits.Window
+	if limits.AccountWindow > maxAge {
+		maxAge = limits.AccountWindow
+	}
+	account.AddOrder(order, maxAge)
+	return a.saveAccount(ctx, account)
+}
+
+func exceeded(limit, key string, times []time.Time, max int, window time.Duration) error {
+	if max <= 0 || len(times) < max {
+		return nil
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return &RateLimitError{
+		Limit:      limit,
+		Key:        key,
+		Count:      max,
+		RetryAfter: times[len(times)-max].Add(window),
+	}
+}
+
+func nameSet(names []string) string {
+	set := make([]string, len(names))
+	for i, name := range names {
+		set[i] = strings.ToLower(name)
+	}
+	sort.Strings(set)
+	return strings.Join(set, ",")
+}
+
+func registeredDomains(names []string) map[string]bool {
+	domains := make(map[string]bool)
+	for _, name := range names {
+		domain, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(strings.TrimPrefix(name, "*.")))
+		if err != nil {
+			domain = strings.ToLower(name)
+		}
+		domains[domain] = true
+	}
+	return domains
+}
@@ -0,0 +1,215 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+const (
+	testCAServer = "https://ca.example.com/directory"
+	testAccount  = "https://ca.example.com/reg/1"
+)
+
+// testOrders returns n orders of the names made at the time.
+func testOrders(n int, at time.Time, issued, renewal bool, names ...string) []*types.Order {
+	orders := make([]*types.Order, n)
+	for i := range orders {
+		orders[i] = &types.Order{
+			Account:  testAccount,
+			CAServer: testCAServer,
+			Issued:   issued,
+			Names:    names,
+			Renewal:  renewal,
+			Time:     at,
+		}
+	}
+	return orders
+}
+
+func TestCheckRateLimits(t *testing.T) {
+	now := time.Now()
+	week := 7 * 24 * time.Hour
+	certLimits := RateLimits{CertificatesPerDomain: 2, DuplicateCertificates: 2, Window: week}
+	concat := func(orders ...[]*types.Order) []*types.Order {
+		var all []*types.Order
+		for _, o := range orders {
+			all = append(all, o...)
+		}
+		return all
+	}
+	otherCA := testOrders(2, now, true, false, "a.example.com")
+	for _, o := range otherCA {
+		o.CAServer = "https://other-ca.example.com/directory"
+	}
+	otherAccount := testOrders(300, now, false, false, "www.example.com")
+	for _, o := range otherAccount {
+		o.Account = "https://ca.example.com/reg/2"
+	}
+
+	tests := []struct {
+		name    string
+		limits  RateLimits
+		orders  []*types.Order
+		names   []string
+		renewal bool
+		limit   string
+	}{
+		{"below the domain limit", certLimits, testOrders(1, now, true, false, "a.example.com"),
+			[]string{"b.example.com"}, false, ""},
+		{"at the domain limit", certLimits, concat(testOrders(1, now, true, false, "a.example.com"), testOrders(1, now, true, false, "b.example.com")),
+			[]string{"c.example.com"}, false, "CertificatesPerDomain"},
+		{"domain orders at the end of the window", certLimits, testOrders(2, now.Add(-week), true, false, "a.example.com"),
+			[]string{"b.example.com"}, false, ""},
+		{"domain orders within the window", certLimits, testOrders(2, now.Add(-week+time.Minute), true, false, "a.example.com"),
+			[]string{"b.example.com"}, false, "CertificatesPerDomain"},
+		{"orders not issued", certLimits, testOrders(2, now, false, false, "a.example.com"),
+			[]string{"b.example.com"}, false, ""},
+		{"orders of another CA", certLimits, otherCA,
+			[]string{"b.example.com"}, false, ""},
+		{"registered domain below a public suffix", certLimits, concat(testOrders(1, now, true, false, "a.example.co.uk"), testOrders(1, now, true, false, "b.example.co.uk")),
+			[]string{"c.example.co.uk"}, false, "CertificatesPerDomain"},
+		{"other registered domain of the public suffix", certLimits, concat(testOrders(1, now, true, false, "a.other.co.uk"), testOrders(1, now, true, false, "b.other.co.uk")),
+			[]string{"a.example.co.uk"}, false, ""},
+		{"below the duplicate limit", RateLimits{DuplicateCertificates: 2, Window: week}, testOrders(1, now, true, false, "www.example.com", "example.com"),
+			[]string{"example.com", "WWW.example.com"}, false, ""},
+		{"at the duplicate limit", RateLimits{DuplicateCertificates: 2, Window: week}, testOrders(2, now, true, false, "www.example.com", "example.com"),
+			[]string{"example.com", "WWW.example.com"}, false, "DuplicateCertificates"},
+		{"renewal exempt from the domain limit", certLimits, concat(testOrders(1, now, true, false, "a.example.com"), testOrders(1, now, true, false, "b.example.com")),
+			[]string{"c.example.com"}, true, ""},
+		{"renewals not counted in the domain limit", certLimits, concat(testOrders(1, now, true, true, "a.example.com"), testOrders(1, now, true, true, "b.example.com")),
+			[]string{"c.example.com"}, false, ""},
+		{"renewal counted in the duplicate limit", certLimits, testOrders(2, now, true, true, "a.example.com"),
+			[]string{"a.example.com"}, true, "DuplicateCertificates"},
+		{"below the account limit", DefaultRateLimits, testOrders(299, now.Add(-time.Hour), false, false, "www.example.com"),
+			[]string{"www.example.com"}, false, ""},
+		{"at the account limit", DefaultRateLimits, testOrders(300, now.Add(-time.Hour), false, false, "www.example.com"),
+			[]string{"www.example.com"}, false, "OrdersPerAccount"},
+		{"account orders at the end of the window", DefaultRateLimits, testOrders(300, now.Add(-3*time.Hour), false, false, "www.example.com"),
+			[]string{"www.example.com"}, false, ""},
+		{"orders of another account", DefaultRateLimits, otherAccount,
+			[]string{"www.example.com"}, false, ""},
+	}
+	for _, test := range tests {
+		a := &ACME{RateLimits: &test.limits}
+		order := &types.Order{Account: testAccount, CAServer: testCAServer, Names: test.names, Renewal: test.renewal, Time: now}
+		err := a.checkRateLimits(&types.Account{Orders: test.orders}, order)
+		if test.limit == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", test.name, err)
+			}
+			continue
+		}
+		rateLimitErr, ok := err.(*RateLimitError)
+		if !ok || rateLimitErr.Limit != test.limit {
+			t.Errorf("%s: expected rate limit %q exceeded, got %v", test.name, test.limit, err)
+		}
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	now := time.Now()
+	orders := append(testOrders(1, now.Add(-2*time.Hour), false, false, "www.example.com"),
+		testOrders(299, now.Add(-time.Hour), false, false, "www.example.com")...)
+	a := &ACME{}
+	err := a.checkRateLimits(&types.Account{Orders: orders}, &types.Order{Account: testAccount, CAServer: testCAServer, Time: now})
+	rateLimitErr, ok := err.(*RateLimitError)
+	if !ok {
+		t.Fatalf("Expected a rate limit error, got %v", err)
+	}
+	if expected := now.Add(-2 * time.Hour).Add(DefaultRateLimits.AccountWindow); !rateLimitErr.RetryAfter.Equal(expected) {
+		t.Errorf("Expected retry after the oldest order leaves the window at %s, got %s", expected, rateLimitErr.RetryAfter)
+	}
+	if rateLimitErr.Key != testAccount || rateLimitErr.Count != 300 {
+		t.Errorf("Expected the limit of 300 orders of the account, got %+v", rateLimitErr)
+	}
+}
+
+func TestRecordOrderPrunesLedger(t *testing.T) {
+	now := time.Now()
+	week := 7 * 24 * time.Hour
+	a := &ACME{
+		Domain:     &types.Domain{Main: "www.example.com"},
+		RateLimits: &RateLimits{Window: week, AccountWindow: 3 * time.Hour},
+		Logger:     log.New(ioutil.Discard, "", 0),
+	}
+	b := &memoryBackend{}
+	a.storage = backend.WithContext(b)
+	kept := testOrders(1, now.Add(-week+time.Hour), true, false, "www.example.com")
+	account := &types.Account{
+		DomainsCertificate: &types.DomainCertificate{Domain: a.Domain},
+		Orders:             append(testOrders(2, now.Add(-week), true, false, "www.example.com"), kept...),
+	}
+	order := &types.Order{Account: testAccount, CAServer: testCAServer, Names: []string{"www.example.com"}, Time: now}
+
+	if err := a.recordOrder(context.Background(), account, order); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := b.LoadAccount("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Orders) != 2 || !stored.Orders[0].Time.Equal(kept[0].Time) || stored.Orders[1].Issued {
+		t.Errorf("Expected the orders out of the window pruned and the new order recorded, got %+v", stored.Orders)
+	}
+}
+
+func TestRateLimitedOrderRetry(t *testing.T) {
+	server := newTestACMEServer(t)
+	b := &memoryBackend{}
+	newACME := func() *ACME {
+		return &ACME{
+			Email:      "user@example.com",
+			Domain:     &types.Domain{Main: "www.example.com"},
+			Backend:    b,
+			Challenges: []acme.Challenge{acme.HTTP01},
+			CAServer:   server.directory(),
+			RateLimits: &RateLimits{DuplicateCertificates: 1, Window: 2 * time.Second},
+			Logger:     log.New(ioutil.Discard, "", 0),
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := newACME().CreateConfigContext(ctx, &tls.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	// the certificate is lost but the order stays in the ledger.
+	stored, err := b.LoadAccount("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.DomainsCertificate.Certificate = &types.Certificate{}
+	if err = b.SaveAccount(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newACME()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	if err = a.CreateConfigContext(ctx, &tls.Config{}); err != nil {
+		t.Fatalf("Expected the rate limited order deferred, got %v", err)
+	}
+	if a.Certificates() != nil {
+		t.Fatal("Expected no certificate before the end of the window")
+	}
+	a.mu.RLock()
+	nextCheck := a.nextCheck
+	a.mu.RUnlock()
+	if nextCheck.After(time.Now().Add(2 * time.Second)) {
+		t.Errorf("Expected the next check at the end of the window, got %s", nextCheck)
+	}
+	for i := 0; i < 100 && a.Certificates() == nil; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if a.Certificates() == nil {
+		t.Error("Expected the certificate obtained at the end of the window")
+	}
+}
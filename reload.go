@@ -0,0 +1,82 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jtblin/go-acme/types"
+)
+
+var errNotInitialised = errors.New("ACME certificate not initialised, call CreateConfig first")
+
+// Renew renews the certificate listing the domain, as main domain or SAN, if it expires soon.
+// When force is true, a new certificate with a new private key is obtained regardless
+// of its expiry e.g. after a key compromise.
+func (a *ACME) Renew(domain string, force bool) error {
+	if a.loadedAccount() == nil {
+		return errNotInitialised
+	}
+	if !a.lists(domain) {
+		return fmt.Errorf("Unknown domain %q", domain)
+	}
+	a.Logger.Printf("Renewing ACME certificate for %q (force: %t)...\n", a.Domain.Main, force)
+	return a.renewCertificates(a.ctx, force)
+}
+
+// lists returns whether the domain is the main domain or one of the SANs of the certificate.
+func (a *ACME) lists(domain string) bool {
+	for _, name := range append([]string{a.Domain.Main}, a.Domain.SANs...) {
+		if strings.EqualFold(name, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload loads the account from the storage backend and swaps in its certificate
+// e.g. after it was renewed by another replica.
+func (a *ACME) Reload() error {
+	if a.loadedAccount() == nil {
+		return errNotInitialised
+	}
+	a.orderMu.Lock()
+	defer a.orderMu.Unlock()
+
+	a.Logger.Printf("Reloading ACME certificate from storage %q...\n", a.storage.Name())
+	account, err := a.loadAccount(a.ctx, a.Domain.Main)
+	if err != nil {
+		return err
+	}
+	if account == nil || account.DomainsCertificate == nil {
+		return fmt.Errorf("ACME account for %q not found in storage %q", a.Domain.Main, a.storage.Name())
+	}
+	if err = a.swapAccount(account); err != nil {
+		return err
+	}
+	a.Logger.Println("Reloaded ACME certificate")
+	return nil
+}
+
+// swapAccount swaps in the account loaded from the storage backend and its certificate.
+func (a *ACME) swapAccount(account *types.Account) error {
+	account.Logger = a.Logger
+	if err := account.DomainsCertificate.Init(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.account = account
+	a.caServer = lastIssuer(account)
+	// the registration may have changed too.
+	a.client = nil
+	return nil
+}
+
+// loadedAccount returns the account, nil before CreateConfig.
+func (a *ACME) loadedAccount() *types.Account {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.account
+}
@@ -0,0 +1,130 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/types"
+)
+
+// newTestReplica returns an ACME instance of the domain issued by the server and stored in b.
+func newTestReplica(t *testing.T, server *testACMEServer, b backend.Interface) *ACME {
+	a := &ACME{
+		Email:      "user@example.com",
+		Domain:     &types.Domain{Main: "www.example.com"},
+		Backend:    b,
+		Challenges: []acme.Challenge{acme.HTTP01},
+		CAServer:   server.directory(),
+		Logger:     log.New(ioutil.Discard, "", 0),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := a.CreateConfigContext(ctx, &tls.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestRenew(t *testing.T) {
+	if err := (&ACME{Domain: &types.Domain{Main: "www.example.com"}}).Renew("www.example.com", true); err != errNotInitialised {
+		t.Errorf("Expected %v before CreateConfig, got %v", errNotInitialised, err)
+	}
+	server := newTestACMEServer(t)
+	a := newTestReplica(t, server, &memoryBackend{})
+	serial := a.Certificates()[0].SerialNumber
+
+	if err := a.Renew("other.example.com", true); err == nil {
+		t.Error("Expected an error for an unknown domain")
+	}
+	// the certificate does not expire soon.
+	if err := a.Renew("www.example.com", false); err != nil {
+		t.Fatal(err)
+	}
+	if a.Certificates()[0].SerialNumber != serial {
+		t.Error("Expected the certificate to be kept without force")
+	}
+	if err := a.Renew("www.example.com", true); err != nil {
+		t.Fatal(err)
+	}
+	if a.Certificates()[0].SerialNumber == serial {
+		t.Error("Expected a new certificate with force")
+	}
+	stored, err := a.Backend.LoadAccount("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.DomainsCertificate.Certificate.Cert) != string(a.loadedAccount().DomainsCertificate.Certificate.Cert) {
+		t.Error("Expected the renewed certificate to be saved")
+	}
+}
+
+func TestRenewSAN(t *testing.T) {
+	server := newTestACMEServer(t)
+	a := &ACME{
+		Email:      "user@example.com",
+		Domain:     &types.Domain{Main: "www.example.com", SANs: []string{"api.example.com"}},
+		Backend:    &memoryBackend{},
+		Challenges: []acme.Challenge{acme.HTTP01},
+		CAServer:   server.directory(),
+		Logger:     log.New(ioutil.Discard, "", 0),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := a.CreateConfigContext(ctx, &tls.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	serial := a.Certificates()[0].SerialNumber
+
+	if err := a.Renew("API.example.com", true); err != nil {
+		t.Fatalf("Expected the certificate listing the SAN renewed, got %v", err)
+	}
+	if a.Certificates()[0].SerialNumber == serial {
+		t.Error("Expected a new certificate with force")
+	}
+}
+
+func TestReload(t *testing.T) {
+	if err := (&ACME{}).Reload(); err != errNotInitialised {
+		t.Errorf("Expected %v before CreateConfig, got %v", errNotInitialised, err)
+	}
+	server := newTestACMEServer(t)
+	b := &memoryBackend{}
+	a := newTestReplica(t, server, b)
+	serial := a.Certificates()[0].SerialNumber
+
+	// another replica with another CA renews the certificate.
+	other := newTestACMEServer(t)
+	replica := &ACME{
+		Email:      "user@example.com",
+		Domain:     a.Domain,
+		Backend:    b,
+		Challenges: []acme.Challenge{acme.HTTP01},
+		CAServer:   other.directory(),
+		Logger:     log.New(ioutil.Discard, "", 0),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := replica.CreateConfigContext(ctx, &tls.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.Renew("www.example.com", true); err != nil {
+		t.Fatal(err)
+	}
+	if a.Certificates()[0].SerialNumber != serial {
+		t.Fatal("Expected the certificate to be kept until reloaded")
+	}
+
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	info := a.Certificates()[0]
+	if info.SerialNumber != replica.Certificates()[0].SerialNumber || info.CAServer != other.directory() {
+		t.Errorf("Expected the certificate issued by %q to be reloaded, got %+v", other.directory(), info)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package acme
+
+// HandleSignals does nothing on platforms without SIGHUP and SIGUSR1, use Reload and Renew instead.
+func (a *ACME) HandleSignals(stop <-chan struct{}) {}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package acme
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleSignals reloads the certificate from storage on SIGHUP and forces its renewal
+// on SIGUSR1 until stop is closed.
+func (a *ACME) HandleSignals(stop <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case sig := <-signals:
+				var err error
+				switch sig {
+				case syscall.SIGHUP:
+					err = a.Reload()
+				case syscall.SIGUSR1:
+					err = a.Renew(a.Domain.Main, true)
+				}
+				if err != nil {
+					a.Logger.Printf("Error handling signal %s: %s\n", sig, err.Error())
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package acme
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleSignals(t *testing.T) {
+	server := newTestACMEServer(t)
+	b := &memoryBackend{}
+	a := newTestReplica(t, server, b)
+	serial := a.Certificates()[0].SerialNumber
+	stop := make(chan struct{})
+	defer close(stop)
+	a.HandleSignals(stop)
+
+	tests := []struct {
+		signal syscall.Signal
+		// renew renews the certificate before the signal, through another replica when reloading.
+		renew func() error
+	}{
+		{syscall.SIGUSR1, func() error { return nil }},
+		{syscall.SIGHUP, func() error { return newTestReplica(t, server, b).Renew("www.example.com", true) }},
+	}
+	for _, test := range tests {
+		if err := test.renew(); err != nil {
+			t.Fatal(err)
+		}
+		if err := syscall.Kill(syscall.Getpid(), test.signal); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(10 * time.Second)
+		for a.Certificates()[0].SerialNumber == serial && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if a.Certificates()[0].SerialNumber == serial {
+			t.Fatalf("Expected a new certificate after %s", test.signal)
+		}
+		serial = a.Certificates()[0].SerialNumber
+	}
+}
@@ -0,0 +1,126 @@
+package acme
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+// Solver holds the challenge configuration of the identifiers matching its key in ACME.Solvers.
+type Solver struct {
+	Challenge acme.Challenge
+	// DNSProvider is the DNS provider name for the DNS-01 challenge (default ACME.DNSProvider).
+	DNSProvider string
+	// DNSConfig is the DNS provider configuration (default ACME.DNSConfig).
+	DNSConfig *dnsprovider.Config
+}
+
+func (s Solver) dnsProvider(defaultProvider string) string {
+	if s.DNSProvider != "" {
+		return s.DNSProvider
+	}
+	return defaultProvider
+}
+
+func (s Solver) dnsConfig(defaultConfig *dnsprovider.Config) *dnsprovider.Config {
+	if s.DNSConfig != nil {
+		return s.DNSConfig
+	}
+	return defaultConfig
+}
+
+// initSolvers reads the solvers of the configuration with their keys in lower case.
+func (a *ACME) initSolvers() error {
+	a.solvers = make(map[string]Solver, len(a.Solvers))
+	for key, solver := range a.Solvers {
+		lower := strings.ToLower(key)
+		if _, found := a.solvers[lower]; found {
+			return fmt.Errorf("Duplicate solvers for %q", lower)
+		}
+		a.solvers[lower] = solver
+	}
+	return nil
+}
+
+// solver returns the key and solver for the domain, matching the exact name first then the
+// longest suffix starting with a dot e.g. ".internal.my-domain.io".
+func (a *ACME) solver(domain string) (string, Solver, bool) {
+	domain = strings.ToLower(domain)
+	if solver, found := a.solvers[domain]; found {
+		return domain, solver, true
+	}
+	var match string
+	for key := range a.solvers {
+		if strings.HasPrefix(key, ".") && strings.HasSuffix(domain, key) && len(key) > len(match) {
+			match = key
+		}
+	}
+	if match == "" {
+		return "", Solver{}, false
+	}
+	return match, a.solvers[match], true
+}
+
+// providerFor returns the provider solving the challenge for the domain.
+func (a *ACME) providerFor(challenge acme.Challenge, domain string) (acme.ChallengeProvider, error) {
+	key, solver, found := a.solver(domain)
+	if !found {
+		if !hasChallenge(a.challenges(), challenge) {
+			return nil, fmt.Errorf("Challenge %q is not enabled for %q", challenge, domain)
+		}
+		return a.defaultProvider(challenge), nil
+	}
+	if solver.Challenge != challenge {
+		return nil, fmt.Errorf("Challenge %q chosen by the CA for %q but its solver uses %q", challenge, domain, solver.Challenge)
+	}
+	if challenge == acme.DNS01 {
+		return a.dnsProviders[key], nil
+	}
+	return a.defaultProvider(challenge), nil
+}
+
+// solverDispatcher dispatches the challenges of a type to the provider of the solver
+// of each authorization and implements the acme.ChallengeProviderTimeout interface.
+type solverDispatcher struct {
+	acme      *ACME
+	challenge acme.Challenge
+}
+
+// Present presents the challenge with the provider for the domain.
+func (d *solverDispatcher) Present(domain, token, keyAuth string) error {
+	provider, err := d.acme.providerFor(d.challenge, domain)
+	if err != nil {
+		return err
+	}
+	return provider.Present(domain, token, keyAuth)
+}
+
+// CleanUp cleans up the challenge with the provider for the domain.
+func (d *solverDispatcher) CleanUp(domain, token, keyAuth string) error {
+	provider, err := d.acme.providerFor(d.challenge, domain)
+	if err != nil {
+		return err
+	}
+	return provider.CleanUp(domain, token, keyAuth)
+}
+
+// Timeout returns the longest timeout and interval of the DNS providers.
+func (d *solverDispatcher) Timeout() (timeout, interval time.Duration) {
+	timeout, interval = 60*time.Second, 2*time.Second
+	for _, provider := range d.acme.dnsProviders {
+		if p, ok := provider.(acme.ChallengeProviderTimeout); ok {
+			t, i := p.Timeout()
+			if t > timeout {
+				timeout = t
+			}
+			if i > interval {
+				interval = i
+			}
+		}
+	}
+	return timeout, interval
+}
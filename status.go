@@ -0,0 +1,102 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/types"
+)
+
+// CertificateInfo holds the status of a managed certificate.
+type CertificateInfo struct {
+	Names        []string
+	Issuer       string
+	CAServer     string
+	SerialNumber string
+	KeyType      acme.KeyType
+	NotBefore    time.Time
+	NotAfter     time.Time
+	// NextRenewal is the time of the first renewal check that will renew the certificate.
+	NextRenewal time.Time
+	// LastRenewalError is the error of the last renewal check if it failed.
+	LastRenewalError string
+	Backend          string
+}
+
+// Certificates returns the status of the managed certificates.
+// It is safe to call concurrently.
+func (a *ACME) Certificates() []CertificateInfo {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.account == nil || a.account.DomainsCertificate.TLSCert == nil {
+		return nil
+	}
+	cert := a.account.DomainsCertificate.TLSCert
+	if len(cert.Certificate) == 0 {
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		a.Logger.Printf("Error parsing ACME certificate: %s\n", err.Error())
+		return nil
+	}
+	var renewalErr string
+	if a.renewalErr != nil {
+		renewalErr = a.renewalErr.Error()
+	}
+	return []CertificateInfo{{
+		Names:            leaf.DNSNames,
+		Issuer:           leaf.Issuer.CommonName,
+		CAServer:         a.caServer,
+		SerialNumber:     leaf.SerialNumber.Text(16),
+		KeyType:          keyType(cert),
+		NotBefore:        leaf.NotBefore,
+		NotAfter:         leaf.NotAfter,
+		NextRenewal:      nextRenewal(a.nextCheck, leaf.NotAfter.Add(-renewBefore)),
+		LastRenewalError: renewalErr,
+		Backend:          a.storage.Name(),
+	}}
+}
+
+// certificate returns the current certificate.
+func (a *ACME) certificate() *tls.Certificate {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.account.DomainsCertificate.TLSCert
+}
+
+// nextRenewal returns the first renewal check at or after renewAt.
+func nextRenewal(nextCheck, renewAt time.Time) time.Time {
+	if !renewAt.After(nextCheck) {
+		return nextCheck
+	}
+	checks := (renewAt.Sub(nextCheck) + renewInterval - 1) / renewInterval
+	return nextCheck.Add(checks * renewInterval)
+}
+
+// lastIssuer returns the CA server of the last issued order.
+func lastIssuer(account *types.Account) string {
+	for i := len(account.Orders) - 1; i >= 0; i-- {
+		if account.Orders[i].Issued {
+			return account.Orders[i].CAServer
+		}
+	}
+	return ""
+}
+
+func keyType(cert *tls.Certificate) acme.KeyType {
+	switch key := cert.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return acme.KeyType(strconv.Itoa(key.N.BitLen()))
+	case *ecdsa.PrivateKey:
+		return acme.KeyType(strings.Replace(key.Curve.Params().Name, "-", "", 1))
+	}
+	return ""
+}
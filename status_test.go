@@ -0,0 +1,94 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/types"
+)
+
+func TestCertificates(t *testing.T) {
+	server := newTestACMEServer(t)
+	a := &ACME{
+		Email:      "user@example.com",
+		Domain:     &types.Domain{Main: "www.example.com", SANs: []string{"example.com"}},
+		Backend:    &memoryBackend{},
+		Challenges: []acme.Challenge{acme.HTTP01},
+		CAServer:   server.directory(),
+		Logger:     log.New(ioutil.Discard, "", 0),
+	}
+	if a.Certificates() != nil {
+		t.Error("Expected no certificates before CreateConfig")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := a.CreateConfigContext(ctx, &tls.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	infos := a.Certificates()
+	if len(infos) != 1 {
+		t.Fatalf("Expected one certificate, got %+v", infos)
+	}
+	info := infos[0]
+	if info.CAServer != server.directory() || info.Backend != "memory" || info.KeyType != acme.RSA2048 {
+		t.Errorf("Expected certificate issued by %q stored in memory, got %+v", server.directory(), info)
+	}
+	if len(info.Names) != 2 || info.Names[0] != "www.example.com" || info.Names[1] != "example.com" {
+		t.Errorf("Expected the names of the domain, got %v", info.Names)
+	}
+	if expiry := time.Now().Add(90 * 24 * time.Hour); info.NotAfter.After(expiry) || info.NotAfter.Before(expiry.Add(-time.Minute)) {
+		t.Errorf("Expected the certificate to expire in 90 days, got %s", info.NotAfter)
+	}
+	renewAt := info.NotAfter.Add(-renewBefore)
+	if info.NextRenewal.Before(renewAt) || !info.NextRenewal.Before(renewAt.Add(renewInterval)) {
+		t.Errorf("Expected the first check after %s to renew the certificate, got %s", renewAt, info.NextRenewal)
+	}
+	if info.LastRenewalError != "" {
+		t.Errorf("Expected no renewal error, got %q", info.LastRenewalError)
+	}
+
+	// a successful forced renewal issues a new certificate.
+	if err := a.Renew("www.example.com", true); err != nil {
+		t.Fatal(err)
+	}
+	renewed := a.Certificates()[0]
+	if renewed.SerialNumber == info.SerialNumber || renewed.LastRenewalError != "" {
+		t.Errorf("Expected a new certificate without renewal error, got %+v", renewed)
+	}
+
+	// a failed renewal keeps the certificate and records the error.
+	server.Close()
+	if err := a.Renew("www.example.com", true); err == nil {
+		t.Fatal("Expected the renewal to fail with the CA down")
+	}
+	failed := a.Certificates()[0]
+	if failed.SerialNumber != renewed.SerialNumber || failed.LastRenewalError == "" {
+		t.Errorf("Expected the certificate kept with the renewal error, got %+v", failed)
+	}
+}
+
+func TestNextRenewal(t *testing.T) {
+	nextCheck := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		renewAt  time.Time
+		expected time.Time
+	}{
+		{nextCheck.Add(-time.Hour), nextCheck},
+		{nextCheck, nextCheck},
+		{nextCheck.Add(time.Hour), nextCheck.Add(renewInterval)},
+		{nextCheck.Add(renewInterval), nextCheck.Add(renewInterval)},
+		{nextCheck.Add(10*renewInterval + time.Second), nextCheck.Add(11 * renewInterval)},
+	}
+	for _, test := range tests {
+		if actual := nextRenewal(nextCheck, test.renewAt); !actual.Equal(test.expected) {
+			t.Errorf("Expected renewal at %s to be checked at %s, got %s", test.renewAt, test.expected, actual)
+		}
+	}
+}
@@ -12,10 +12,14 @@ import (
 
 // Account is used to store lets encrypt registration info
 // and implements the acme.User interface.
+// Orders is the ledger of recent certificate orders used to enforce rate limits.
+// Challenges is the journal of the challenge records presented and not yet cleaned up.
 type Account struct {
 	Email              string
+	Challenges         []*Challenge `json:",omitempty"`
 	DomainsCertificate *DomainCertificate
-	Logger      logger.Interface
+	Logger             logger.Interface `json:"-"`
+	Orders             []*Order         `json:",omitempty"`
 	PrivateKey         []byte
 	Registration       *acme.RegistrationResource
 }
@@ -0,0 +1,39 @@
+package types
+
+import (
+	"time"
+)
+
+// Challenge records a DNS-01 challenge record presented by a DNS provider,
+// journaled until the record is cleaned up.
+type Challenge struct {
+	// Provider is the key of the DNS provider, empty for the default provider.
+	Provider string
+	Domain   string
+	FQDN     string
+	Token    string
+	KeyAuth  string
+	Value    string
+	Time     time.Time
+	// Owner identifies the instance which presented the record.
+	Owner string
+}
+
+// AddChallenge journals the challenge.
+func (a *Account) AddChallenge(challenge *Challenge) {
+	a.Challenges = append(a.Challenges, challenge)
+}
+
+// RemoveChallenge removes the challenge of the provider for the domain and key authorization
+// from the journal and returns true if it was found.
+func (a *Account) RemoveChallenge(provider, domain, keyAuth string) bool {
+	for i, c := range a.Challenges {
+		if c.Provider == provider && c.Domain == domain && c.KeyAuth == keyAuth {
+			// a new slice is built as the journal may be iterated meanwhile.
+			challenges := make([]*Challenge, 0, len(a.Challenges)-1)
+			a.Challenges = append(append(challenges, a.Challenges[:i]...), a.Challenges[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
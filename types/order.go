@@ -0,0 +1,27 @@
+package types
+
+import (
+	"time"
+)
+
+// Order records a certificate order made with a CA.
+type Order struct {
+	// Account is the url of the account registration with the CA.
+	Account  string
+	CAServer string
+	Issued   bool
+	Names    []string
+	Renewal  bool
+	Time     time.Time
+}
+
+// AddOrder records the order and prunes orders older than maxAge.
+func (a *Account) AddOrder(order *Order, maxAge time.Duration) {
+	orders := make([]*Order, 0, len(a.Orders)+1)
+	for _, o := range a.Orders {
+		if order.Time.Sub(o.Time) < maxAge {
+			orders = append(orders, o)
+		}
+	}
+	a.Orders = append(orders, order)
+}
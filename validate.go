@@ -0,0 +1,145 @@
+package acme
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/dnsprovider"
+)
+
+// checkCanary returns true if the canary record is visible on the authoritative nameservers.
+var checkCanary = dnsprovider.CheckAuthoritative
+
+// DNSValidationErrorKind is the kind of DNS provider validation failure.
+type DNSValidationErrorKind int
+
+const (
+	// DNSZoneError is returned when the authoritative zone of a name cannot be found, or
+	// when the canary record is not served by the authoritative nameservers of the zone.
+	DNSZoneError DNSValidationErrorKind = iota + 1
+	// DNSPermissionError is returned when the provider cannot create or delete the canary record.
+	DNSPermissionError
+)
+
+// String implements the fmt.Stringer interface.
+func (k DNSValidationErrorKind) String() string {
+	switch k {
+	case DNSZoneError:
+		return "zone"
+	case DNSPermissionError:
+		return "permission"
+	default:
+		return "unknown"
+	}
+}
+
+// DNSValidationError is returned by ValidateDNSProvider when the DNS provider of a name is misconfigured.
+type DNSValidationError struct {
+	Kind   DNSValidationErrorKind
+	Domain string
+	// Zone is the authoritative zone of the name, if found.
+	Zone string
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *DNSValidationError) Error() string {
+	return fmt.Sprintf("DNS provider validation failed for %s (%s): %v", e.Domain, e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *DNSValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateDNSProvider checks the DNS provider of each name solved with the DNS-01 challenge:
+// it discovers the authoritative zone of the name, then creates a canary challenge record
+// through the provider, checks that the authoritative nameservers serve it, and deletes it.
+// The failures are returned as *DNSValidationError.
+func (a *ACME) ValidateDNSProvider() error {
+	if a.dnsProviders == nil {
+		return errNotInitialised
+	}
+	var errs []error
+	for _, domain := range append([]string{a.Domain.Main}, a.Domain.SANs...) {
+		if !a.usesDNS01(domain) {
+			continue
+		}
+		if err := a.validateDNSProvider(strings.TrimPrefix(domain, "*.")); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a *ACME) validateDNSProvider(domain string) error {
+	a.Logger.Printf("Validating DNS provider for %s...\n", domain)
+	provider, err := a.providerFor(acme.DNS01, domain)
+	if err != nil {
+		return err
+	}
+	// the canary record is not journaled, a leftover is harmless.
+	resolvers := acme.RecursiveNameservers
+	if p, ok := provider.(*journalingProvider); ok {
+		provider, resolvers = p.ChallengeProvider, p.resolvers
+	}
+
+	fqdn, _, _ := acme.DNS01Record(domain, "")
+	zone, err := acme.FindZoneByFqdn(fqdn, resolvers)
+	if err != nil {
+		return &DNSValidationError{Kind: DNSZoneError, Domain: domain, Err: err}
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	keyAuth := token + ".canary"
+	if err := provider.Present(domain, token, keyAuth); err != nil {
+		return &DNSValidationError{Kind: DNSPermissionError, Domain: domain, Zone: zone,
+			Err: fmt.Errorf("Error creating canary record: %v", err)}
+	}
+
+	// a provider updating another zone e.g. a wrong hosted zone id succeeds without the
+	// record ever being served.
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	timeout, interval := 60*time.Second, 2*time.Second
+	if p, ok := provider.(acme.ChallengeProviderTimeout); ok {
+		timeout, interval = p.Timeout()
+	}
+	served := acme.WaitFor(timeout, interval, func() (bool, error) {
+		return checkCanary(fqdn, value, resolvers)
+	})
+
+	if err := provider.CleanUp(domain, token, keyAuth); err != nil {
+		return &DNSValidationError{Kind: DNSPermissionError, Domain: domain, Zone: zone,
+			Err: fmt.Errorf("Error deleting canary record: %v", err)}
+	}
+	if served != nil {
+		return &DNSValidationError{Kind: DNSZoneError, Domain: domain, Zone: zone,
+			Err: fmt.Errorf("Canary record not served by the authoritative nameservers of %s: %v", zone, served)}
+	}
+	return nil
+}
+
+// usesDNS01 returns true if the domain is solved with the DNS-01 challenge.
+func (a *ACME) usesDNS01(domain string) bool {
+	if _, solver, found := a.solver(domain); found {
+		return solver.Challenge == acme.DNS01
+	}
+	return hasChallenge(a.challenges(), acme.DNS01)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
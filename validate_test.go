@@ -0,0 +1,153 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+
+	"github.com/jtblin/go-acme/backend"
+	"github.com/jtblin/go-acme/dnsprovider"
+	"github.com/jtblin/go-acme/types"
+)
+
+// failingDNSProvider cannot create the challenge records.
+type failingDNSProvider struct{}
+
+func (p *failingDNSProvider) Present(domain, token, keyAuth string) error {
+	return errors.New("permission denied")
+}
+
+func (p *failingDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	return nil
+}
+
+func init() {
+	dnsprovider.RegisterProvider("failing", func(config *dnsprovider.Config) (acme.ChallengeProvider, error) {
+		return &failingDNSProvider{}, nil
+	})
+}
+
+func TestCreateConfigValidatesDNS(t *testing.T) {
+	dnsprovider.InstallPreCheckDNS()
+	a := &ACME{
+		Domain:      &types.Domain{Main: "www.example.test"},
+		Backend:     &memoryBackend{},
+		DNSProvider: "failing",
+		ValidateDNS: true,
+		CAServer:    "http://127.0.0.1:0/directory",
+		Propagation: &dnsprovider.Propagation{Resolvers: []string{startZoneResolver(t, "example.test.")}},
+		Logger:      log.New(ioutil.Discard, "", 0),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := a.CreateConfigContext(ctx, &tls.Config{})
+	var verr *DNSValidationError
+	if !errors.As(err, &verr) || verr.Kind != DNSPermissionError {
+		t.Errorf("Expected a DNS permission error before loading the certificate, got %v", err)
+	}
+	if a.loadedAccount() != nil {
+		t.Error("Expected no account loaded with an invalid DNS provider")
+	}
+}
+
+// canaryDNSProvider records the canary records in flight and presented.
+type canaryDNSProvider struct {
+	testDNSProvider
+}
+
+func (p *canaryDNSProvider) Timeout() (timeout, interval time.Duration) {
+	return 50 * time.Millisecond, 10 * time.Millisecond
+}
+
+// startZoneResolver starts a DNS server on localhost answering the SOA record of the zone
+// and returns its address.
+func startZoneResolver(t *testing.T, zone string) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if q := r.Question[0]; q.Qtype == dns.TypeSOA && strings.EqualFold(q.Name, zone) {
+			m.Answer = append(m.Answer, &dns.SOA{
+				Hdr:    dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+				Ns:     "ns." + zone,
+				Mbox:   "hostmaster." + zone,
+				Serial: 1,
+			})
+		}
+		w.WriteMsg(m)
+	})
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestValidateDNSProviderZone(t *testing.T) {
+	resolver := startZoneResolver(t, "example.test.")
+	tests := []struct {
+		name   string
+		domain string
+		served bool
+		kind   DNSValidationErrorKind
+		zone   string
+	}{
+		{"served", "www.example.test", true, 0, ""},
+		{"wrong zone", "www.example.test", false, DNSZoneError, "example.test."},
+		{"no zone", "www.unknown.test", true, DNSZoneError, ""},
+	}
+	for _, test := range tests {
+		b := &memoryBackend{}
+		provider := &canaryDNSProvider{}
+		a := &ACME{
+			Domain:     &types.Domain{Main: test.domain},
+			Challenges: []acme.Challenge{acme.DNS01},
+			Logger:     log.New(ioutil.Discard, "", 0),
+			storage:    backend.WithContext(b),
+			account:    &types.Account{DomainsCertificate: &types.DomainCertificate{Domain: &types.Domain{Main: test.domain}}},
+			ctx:        context.Background(),
+		}
+		a.dnsProviders = map[string]acme.ChallengeProvider{
+			"": &journalingProvider{ChallengeProvider: provider, acme: a, resolvers: []string{resolver}},
+		}
+		var checked []string
+		checkCanary = func(fqdn, value string, resolvers []string) (bool, error) {
+			checked = resolvers
+			return test.served && provider.keyAuth(test.domain) != "", nil
+		}
+
+		err := a.ValidateDNSProvider()
+		checkCanary = dnsprovider.CheckAuthoritative
+		var verr *DNSValidationError
+		switch {
+		case test.kind == 0 && err != nil:
+			t.Errorf("%s: expected the provider to be valid, got %v", test.name, err)
+		case test.kind != 0 && (!errors.As(err, &verr) || verr.Kind != test.kind || verr.Zone != test.zone):
+			t.Errorf("%s: expected a %s error in zone %q, got %v", test.name, test.kind, test.zone, err)
+		}
+		if test.zone != "" || test.kind == 0 {
+			if len(checked) != 1 || checked[0] != resolver {
+				t.Errorf("%s: expected the canary checked through the configured resolver, got %v", test.name, checked)
+			}
+			if provider.presented != 1 || provider.cleanedUp != 1 {
+				t.Errorf("%s: expected the canary presented and cleaned up, got %d and %d", test.name, provider.presented, provider.cleanedUp)
+			}
+		}
+		if account, _ := b.LoadAccount(test.domain); account != nil {
+			t.Errorf("%s: expected the canary not to be journaled, got %+v", test.name, account.Challenges)
+		}
+	}
+}